@@ -0,0 +1,22 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// writable reports why the current user can't change the entries of the
+// directory path, or nil if it can. Without access(2) this only catches
+// read-only attributes.
+func writable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0o200 == 0 {
+		return fmt.Errorf("read-only")
+	}
+	return nil
+}
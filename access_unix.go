@@ -0,0 +1,11 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// writable reports why the current user can't change the entries of the
+// directory path, or nil if it can
+func writable(path string) error {
+	return syscall.Access(path, 0x2) // W_OK
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// archiveEntry is one line of the archive index, tying an archive to the
+// directory it was made from
+type archiveEntry struct {
+	Time    time.Time `json:"time"`
+	Path    string    `json:"path"`
+	Archive string    `json:"archive"`
+	Size    int64     `json:"size"`
+	SHA256  string    `json:"sha256,omitempty"` // of the archive file
+}
+
+// archiveStore is a directory of .tar.zst archives and their index
+type archiveStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// defaultArchiveDir returns where archives go without --archive-dir
+func defaultArchiveDir() (string, error) {
+	return statePath("archives")
+}
+
+// openArchiveStore returns the store in dir, or the default one for ""
+func openArchiveStore(dir string) (*archiveStore, error) {
+	if dir == "" {
+		var err error
+		if dir, err = defaultArchiveDir(); err != nil {
+			return nil, err
+		}
+	}
+	dir = expandHome(dir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &archiveStore{dir: dir}, nil
+}
+
+// indexPath returns the location of the store's index
+func (s *archiveStore) indexPath() string {
+	return filepath.Join(s.dir, "index.jsonl")
+}
+
+// archiveName returns a file name for an archive of path made at t. It stays
+// well below NAME_MAX however deep path is: the project and directory names
+// are cut short and a hash of path keeps names unique, the index has the
+// full path.
+func archiveName(path string, t time.Time) string {
+	label := filepath.Base(filepath.Dir(path)) + "_" + filepath.Base(path)
+	label = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, label)
+	if len(label) > 80 {
+		cut := 80
+		for cut > 0 && !utf8.RuneStart(label[cut]) {
+			cut--
+		}
+		label = label[:cut]
+	}
+	sum := sha256.Sum256([]byte(path))
+	return fmt.Sprintf("%s-%s-%s.tar.zst", label, hex.EncodeToString(sum[:4]), t.Format("20060102-150405.000"))
+}
+
+// archive packs dir into a new archive and records it in the index
+func (s *archiveStore) archive(ctx context.Context, dir Directory) (archiveEntry, error) {
+	path := filepath.Join(s.dir, archiveName(dir.path, time.Now()))
+
+	sum, err := writeArchive(ctx, dir.path, path)
+	if err != nil {
+		os.Remove(path)
+		return archiveEntry{}, fmt.Errorf("archiving %s: %w", dir.path, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.indexPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return archiveEntry{}, err
+	}
+	defer f.Close()
+	entry := archiveEntry{Time: time.Now(), Path: dir.path, Archive: path, Size: dir.size, SHA256: sum}
+	if err := json.NewEncoder(f).Encode(entry); err != nil {
+		return archiveEntry{}, err
+	}
+	return entry, nil
+}
+
+// entries returns the index, oldest first
+func (s *archiveStore) entries() ([]archiveEntry, error) {
+	f, err := os.Open(s.indexPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var list []archiveEntry
+	lines := bufio.NewScanner(f)
+	for lines.Scan() {
+		var entry archiveEntry
+		if json.Unmarshal(lines.Bytes(), &entry) == nil {
+			list = append(list, entry)
+		}
+	}
+	return list, lines.Err()
+}
+
+// find returns the newest archive of the directory at path, or the entry of
+// the archive file at path
+func (s *archiveStore) find(path string) (archiveEntry, bool, error) {
+	list, err := s.entries()
+	if err != nil {
+		return archiveEntry{}, false, err
+	}
+	for i := len(list) - 1; i >= 0; i-- {
+		if list[i].Path == path || list[i].Archive == path {
+			return list[i], true, nil
+		}
+	}
+	return archiveEntry{}, false, nil
+}
+
+// writeArchive writes the tree at src as a zstd-compressed tar to dst and
+// returns the hex SHA-256 of what it wrote
+func writeArchive(ctx context.Context, src, dst string) (string, error) {
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	zw, err := zstd.NewWriter(io.MultiWriter(f, h))
+	if err != nil {
+		return "", err
+	}
+	tw := tar.NewWriter(zw)
+
+	err = filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		in, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		_, err = io.Copy(tw, in)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), f.Close()
+}
+
+// verifyArchive checks the archive at path against the checksum recorded
+// when it was made, if one was
+func verifyArchive(path, sum string) error {
+	if sum == "" {
+		return nil
+	}
+	got, err := fileHash(path)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	if hex.EncodeToString(got) != sum {
+		return fmt.Errorf("%s is damaged: its checksum doesn't match the one recorded when it was made", path)
+	}
+	return nil
+}
+
+// linkedParent reports whether a directory leading to name below dst, or
+// name itself, is a symlink, which an entry could use to escape dst
+func linkedParent(dst, name string) bool {
+	path := dst
+	for _, part := range strings.Split(name, string(filepath.Separator)) {
+		path = filepath.Join(path, part)
+		info, err := os.Lstat(path)
+		if err != nil {
+			return false // not there yet, nothing below it is either
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// extractArchive unpacks the archive at src into the directory dst, which
+// must not exist yet
+func extractArchive(ctx context.Context, src, dst string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	if err := os.Mkdir(dst, 0o755); err != nil {
+		return err
+	}
+	tr := tar.NewReader(zr)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// Refuse entries that would land outside dst
+		name := filepath.FromSlash(strings.TrimSuffix(hdr.Name, "/"))
+		if !filepath.IsLocal(name) || linkedParent(dst, name) {
+			return fmt.Errorf("refusing unsafe path %q in %s", hdr.Name, src)
+		}
+		target := filepath.Join(dst, name)
+		mode := hdr.FileInfo().Mode()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, mode.Perm()|0o700)
+		case tar.TypeSymlink:
+			err = os.Symlink(hdr.Linkname, target)
+		case tar.TypeReg:
+			err = writeFile(tr, target, mode.Perm())
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeSymlink {
+			os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+		}
+	}
+}
+
+// writeFile creates path with the contents of r
+func writeFile(r io.Reader, path string, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// archiveDirectories archives dirs, at most workers at a time, and returns
+// the ones that may be deleted now. Directories that couldn't be archived
+// are reported and left alone.
+func archiveDirectories(ctx context.Context, dirs []Directory, workers int, store *archiveStore, progress io.Writer) ([]Directory, int) {
+	var (
+		archived  []Directory
+		failed    int
+		mutex     sync.Mutex
+		wg        sync.WaitGroup
+		semaphore = make(chan struct{}, max(workers, 1))
+	)
+	for _, dir := range dirs {
+		wg.Add(1)
+		go func(dir Directory) {
+			defer wg.Done()
+			semaphore <- struct{}{}        // Acquire
+			defer func() { <-semaphore }() // Release
+
+			entry, err := store.archive(ctx, dir)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				failed++
+				printError("ERROR:", err)
+				return
+			}
+			dir.archive, dir.archiveSum = entry.Archive, entry.SHA256
+			archived = append(archived, dir)
+			fmt.Fprintf(progress, "Archived [%s] to %s\n", dir.path, entry.Archive)
+		}(dir)
+	}
+	wg.Wait()
+	return archived, failed
+}
+
+// runRestore unpacks archived directories back to where they came from
+func runRestore(ctx context.Context, opts *options, args []string) error {
+	unlock, err := lockRun(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	store, err := openArchiveStore(opts.archiveDir)
+	if err != nil {
+		return fmt.Errorf("opening archive directory: %w", err)
+	}
+
+	if len(args) == 0 {
+		list, err := store.entries()
+		if err != nil {
+			return err
+		}
+		if len(list) == 0 {
+			fmt.Fprintf(opts.progress(), "No archives in %s\n", store.dir)
+			return errNothingFound
+		}
+		for _, entry := range list {
+			fmt.Printf("%s  %10s  %s\n", entry.Time.Format("2006-01-02 15:04"), formatSize(entry.Size), entry.Path)
+		}
+		fmt.Println("\nRun 'clean-modules restore <path>' to unpack one.")
+		return nil
+	}
+
+	for _, arg := range args {
+		path, err := filepath.Abs(expandHome(arg))
+		if err != nil {
+			return err
+		}
+		entry, ok, err := store.find(path)
+		if err != nil {
+			return fmt.Errorf("reading archive index: %w", err)
+		}
+		if !ok {
+			return fmt.Errorf("no archive of %s in %s", path, store.dir)
+		}
+		if _, err := os.Lstat(entry.Path); err == nil {
+			return fmt.Errorf("%s already exists, delete or move it before restoring", entry.Path)
+		}
+
+		if err := verifyArchive(entry.Archive, entry.SHA256); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(opts.progress(), "Restoring %s from %s ⏳\n", entry.Path, entry.Archive)
+		if err := extractArchive(ctx, entry.Archive, entry.Path); err != nil {
+			os.RemoveAll(entry.Path) // it didn't exist before, drop the partial copy
+			return fmt.Errorf("restoring %s: %w", entry.Path, err)
+		}
+		fmt.Printf("Restored %s ✅ (the archive is kept at %s)\n", entry.Path, entry.Archive)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiveName(t *testing.T) {
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	deep := string(filepath.Separator) + strings.Repeat(filepath.Join("packages", strings.Repeat("x", 60))+string(filepath.Separator), 20)
+	tests := []struct {
+		name string
+		path string
+		want string // prefix
+	}{
+		{"project", filepath.Join("/work", "app", "node_modules"), "app_node_modules-"},
+		{"deep monorepo", filepath.Join(deep, strings.Repeat("y", 200), "node_modules"), strings.Repeat("y", 80)},
+		{"unsafe characters", filepath.Join("/work", `a:b*c`, "node_modules"), "a_b_c_node_modules-"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := archiveName(tt.path, at)
+			if len(got) > 255 {
+				t.Errorf("archiveName() is %d bytes long", len(got))
+			}
+			if !strings.HasPrefix(got, tt.want) || !strings.HasSuffix(got, "-20260102-030405.000.tar.zst") {
+				t.Errorf("archiveName() = %q", got)
+			}
+		})
+	}
+	if archiveName(filepath.Join("/a", "app", "node_modules"), at) == archiveName(filepath.Join("/b", "app", "node_modules"), at) {
+		t.Error("different paths got the same archive name")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// auditEntry is one line of the deletion audit log
+type auditEntry struct {
+	Time     time.Time `json:"time"`
+	Run      string    `json:"run,omitempty"` // identifies the run, for undo
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Duration string    `json:"duration"`
+	Outcome  string    `json:"outcome"`           // "deleted", "trashed", "failed" or "restored"
+	Trash    string    `json:"trash,omitempty"`   // where a trashed directory went
+	Archive  string    `json:"archive,omitempty"` // archive made with --archive or --backup
+	SHA256   string    `json:"sha256,omitempty"`  // of the archive
+	Error    string    `json:"error,omitempty"`
+}
+
+// auditLog appends a JSON line per deletion to a file
+type auditLog struct {
+	file *os.File
+	enc  *json.Encoder
+	run  string
+}
+
+// statePath returns the location of a state file, honoring $XDG_STATE_HOME
+func statePath(name string) (string, error) {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "clean-modules", name), nil
+}
+
+// auditLogPath returns the default audit log location
+func auditLogPath() (string, error) {
+	return statePath("audit.log")
+}
+
+// openAuditLog opens path for appending, creating it and its parent
+// directories when needed
+func openAuditLog(path string) (*auditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{file: f, enc: json.NewEncoder(f), run: time.Now().Format(time.RFC3339Nano)}, nil
+}
+
+// record appends the outcome of one deletion. A nil log records nothing.
+func (l *auditLog) record(res deleteResult) error {
+	if l == nil {
+		return nil
+	}
+	entry := auditEntry{
+		Time:     time.Now(),
+		Run:      l.run,
+		Path:     res.dir.path,
+		Size:     res.dir.size,
+		Duration: res.duration.Round(time.Millisecond).String(),
+		Outcome:  "deleted",
+		Archive:  res.dir.archive,
+		SHA256:   res.dir.archiveSum,
+	}
+	if res.trashed {
+		entry.Outcome, entry.Trash = "trashed", res.trash
+	}
+	if res.err != nil {
+		entry.Outcome = "failed"
+		entry.Error = res.err.Error()
+	}
+	return l.enc.Encode(entry)
+}
+
+// recordRestore notes that undo brought back path, deleted in run. A nil
+// log records nothing.
+func (l *auditLog) recordRestore(path, run string) error {
+	if l == nil {
+		return nil
+	}
+	return l.enc.Encode(auditEntry{Time: time.Now(), Run: run, Path: path, Outcome: "restored"})
+}
+
+// readAuditLog returns the entries of the audit log at path, oldest first
+func readAuditLog(path string) ([]auditEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []auditEntry
+	lines := bufio.NewScanner(f)
+	for lines.Scan() {
+		var entry auditEntry
+		if json.Unmarshal(lines.Bytes(), &entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, lines.Err()
+}
+
+// Close closes the underlying file. A nil log is a no-op.
+func (l *auditLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
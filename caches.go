@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// cacheDetectors returns the detectors whose global caches the caches
+// command shows: the selected ones, or every detector with a global cache
+func cacheDetectors(opts *options) ([]*detector, error) {
+	if len(opts.detectors) > 0 || len(opts.categories) > 0 {
+		return selectDetectors(nil, opts.detectors, opts.categories)
+	}
+	var list []*detector
+	for _, d := range knownDetectors {
+		if len(d.global) > 0 {
+			list = append(list, d)
+		}
+	}
+	return list, nil
+}
+
+// runCaches lists the machine-wide package manager and build caches and,
+// with --clear, deletes the selected ones
+func runCaches(ctx context.Context, opts *options, args []string) error {
+	detectors, err := cacheDetectors(opts)
+	if err != nil {
+		return err
+	}
+	scan := scanOptions{diskUsage: opts.diskUsage, errors: &walkErrors{}}
+
+	progress := opts.progress()
+	fmt.Fprintln(progress, "Sizing global caches...")
+	dirs := globalDirectories(ctx, detectors, scan)
+	scan.errors.report(os.Stderr, opts.showErrors)
+	if len(dirs) == 0 {
+		fmt.Fprintln(progress, "No global caches found")
+		return errNothingFound
+	}
+	sortDirectories(dirs, "size", false)
+
+	if !opts.clear {
+		for _, dir := range dirs {
+			size := fmt.Sprintf("%10s", dir.sizeString())
+			fmt.Printf("%s  %-14s %s\n", paintSize(os.Stdout, dir.size, size), dir.kind, dir.path)
+		}
+		fmt.Printf("\nFound %d global caches (total size: %s)\n", len(dirs), paint(os.Stdout, ansiBold, formatSize(sumSizes(dirs))))
+		return nil
+	}
+
+	if err := guardRoot(opts); err != nil {
+		return err
+	}
+	unlock, err := lockRun(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	selected := dirs
+	if !opts.yes {
+		if selected, err = selectDirectories(dirs, opts.selectAll, false); err != nil {
+			return fmt.Errorf("during selection: %w", err)
+		}
+	}
+	if selected, err = guardProtected(selected, opts); err != nil {
+		return err
+	}
+	if len(selected) == 0 {
+		fmt.Fprintln(progress, "No caches selected for deletion.")
+		return errAborted
+	}
+
+	totalSize := totalSizeString(selected)
+	if opts.dryRun {
+		printDryRun(os.Stdout, selected, totalSize)
+		return nil
+	}
+	if !opts.yes {
+		confirm, err := confirmDeletion(selected, totalSize, opts)
+		if err != nil {
+			return fmt.Errorf("during confirmation: %w", err)
+		}
+		if !confirm {
+			fmt.Fprintln(progress, "Operation cancelled.")
+			return errAborted
+		}
+	}
+
+	audit, err := openAudit(opts)
+	if err != nil {
+		printWarning("audit log disabled: %v", err)
+	}
+	defer audit.Close()
+	selected, denied := checkPermissions(selected)
+	summary := deleteDirectories(ctx, selected, max(opts.workers, 1), opts.trash, progress, audit)
+	if err := summary.interrupted(); err != nil {
+		return err
+	}
+	failed := summary.failed + denied
+	fmt.Printf("\nCleared %d caches (%s freed), %d failed\n", summary.deleted, formatSize(summary.freed), failed)
+	if failed > 0 {
+		return &codeError{
+			code: exitPartialFailure,
+			err:  fmt.Errorf("%d of %d caches could not be deleted", failed, len(selected)+denied),
+		}
+	}
+	return nil
+}
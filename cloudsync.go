@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// syncFolders are where cloud storage clients keep the folders they sync.
+// Patterns may contain globs, as macOS names provider folders per account.
+var syncFolders = []struct {
+	service string
+	path    string
+}{
+	{"Dropbox", "~/Dropbox"},
+	{"Dropbox", "~/Dropbox (*)"},
+	{"Dropbox", "~/Library/CloudStorage/Dropbox*"},
+	{"OneDrive", "$OneDrive"},
+	{"OneDrive", "$OneDriveConsumer"},
+	{"OneDrive", "$OneDriveCommercial"},
+	{"OneDrive", "~/OneDrive"},
+	{"OneDrive", "~/OneDrive - *"},
+	{"OneDrive", "~/Library/CloudStorage/OneDrive*"},
+	{"iCloud Drive", "~/Library/Mobile Documents"},
+	{"iCloud Drive", "~/iCloudDrive"},
+	{"Google Drive", "~/Google Drive"},
+	{"Google Drive", "~/My Drive"},
+	{"Google Drive", "~/Library/CloudStorage/GoogleDrive*"},
+}
+
+var (
+	syncOnce  sync.Once
+	syncPaths map[string]string // expanded sync folder to its service
+)
+
+// loadSyncFolders expands syncFolders and adds the folders the Dropbox
+// client reports in its info.json, which may be anywhere
+func loadSyncFolders() {
+	syncPaths = make(map[string]string)
+	for _, folder := range syncFolders {
+		pattern, ok := expandGlobal(folder.path)
+		if !ok {
+			continue
+		}
+		matches, _ := filepath.Glob(pattern)
+		for _, p := range matches {
+			syncPaths[p] = folder.service
+		}
+	}
+
+	for _, raw := range []string{"~/.dropbox/info.json", "$LOCALAPPDATA/Dropbox/info.json", "$APPDATA/Dropbox/info.json"} {
+		path, ok := expandGlobal(raw)
+		if !ok {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var accounts map[string]struct {
+			Path string `json:"path"`
+		}
+		if json.Unmarshal(data, &accounts) != nil {
+			continue
+		}
+		for _, account := range accounts {
+			if account.Path != "" {
+				syncPaths[filepath.Clean(account.Path)] = "Dropbox"
+			}
+		}
+	}
+}
+
+// syncService returns the cloud storage service syncing path, or "" when
+// it isn't inside a synced folder
+func syncService(path string) string {
+	syncOnce.Do(loadSyncFolders)
+	for dir := path; ; {
+		if service := syncPaths[dir]; service != "" {
+			return service
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Color modes selectable with --color
+const (
+	colorAuto   = "auto"   // color terminals unless NO_COLOR is set
+	colorAlways = "always" // color everything, even pipes and files
+	colorNever  = "never"  // plain text only
+)
+
+// ANSI escape codes used by the output layer
+const (
+	ansiReset  = "\x1b[0m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiBold   = "\x1b[1m"
+)
+
+// colorMode is the mode used by paint, set once from the command line
+var colorMode = colorAuto
+
+// setColorMode selects when output is colored
+func setColorMode(mode string) error {
+	switch mode {
+	case "":
+		colorMode = colorAuto
+		return nil
+	case colorAuto, colorAlways, colorNever:
+		colorMode = mode
+		return nil
+	}
+	return fmt.Errorf("unknown --color %q (use auto, always or never)", mode)
+}
+
+// colorEnabled reports whether text written to w should be colored. In auto
+// mode only terminals get color, and NO_COLOR or TERM=dumb turn it off.
+func colorEnabled(w io.Writer) bool {
+	switch colorMode {
+	case colorAlways:
+		return true
+	case colorNever:
+		return false
+	}
+	if _, ok := os.LookupEnv("NO_COLOR"); ok || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// paint wraps s in the ANSI code when w gets colored output
+func paint(w io.Writer, code, s string) string {
+	if !colorEnabled(w) {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+// paintSize colors an already formatted size by magnitude: red from 1 GiB,
+// yellow from 100 MiB and green below
+func paintSize(w io.Writer, size int64, s string) string {
+	switch {
+	case size >= 1<<30:
+		return paint(w, ansiRed, s)
+	case size >= 100<<20:
+		return paint(w, ansiYellow, s)
+	}
+	return paint(w, ansiGreen, s)
+}
+
+// printError reports a problem on stderr with a red prefix
+func printError(prefix string, err error) {
+	fmt.Fprintf(os.Stderr, "%s %v\n", paint(os.Stderr, ansiRed, prefix), err)
+}
+
+// printWarning reports a recoverable problem on stderr with a yellow prefix
+func printWarning(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "%s %s\n", paint(os.Stderr, ansiYellow, "Warning:"), fmt.Sprintf(format, args...))
+}
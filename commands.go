@@ -0,0 +1,868 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// options holds the command-line flags, pre-filled from the config file
+type options struct {
+	dryRun     bool
+	yes        bool
+	format     string   // scan output format: text, json, csv or yaml
+	output     string   // file to write scan results to instead of stdout
+	roots      []string // default roots when none are given on the command line
+	protected  []string // globs that are never deleted, from protected_paths
+	pinned     []string // active projects kept out of the results, from pinned
+	olderThan  ageValue
+	minSize    sizeValue
+	profile    string
+	names      stringsValue
+	detectors  stringsValue
+	categories stringsValue
+	excludes   stringsValue
+	includes   stringsValue
+	maxDepth   int
+	sortBy     string
+	reverse    bool
+	workers    int
+	stdin      bool
+	quiet      bool
+	verbose    bool
+	debug      bool
+	top        int
+	duplicates int
+
+	followSymlinks  bool
+	noSize          bool
+	diskUsage       bool
+	oneFileSystem   bool
+	skipHidden      bool
+	allowOrphans    bool
+	showErrors      bool
+	globalCaches    bool
+	prune           bool
+	prunePnpm       bool
+	reinstall       bool
+	recentDays      int
+	allowRecent     bool
+	allowInUse      bool
+	allowRoot       bool
+	wait            bool
+	trash           bool
+	workspaceUnits  bool
+	archive         bool
+	backup          string
+	archiveDir      string
+	pruneDev        bool
+	pruneExtraneous bool
+	clear           bool
+	undo            bool
+	reflink         bool
+	sizeFormat      string
+	color           string
+	selectAll       bool
+	timeout         time.Duration
+	report          string
+	free            sizeValue
+	confirmSize     sizeValue // deleting a larger directory needs its project name typed
+	confirmTotal    sizeValue // as does deleting more than this altogether
+	keepRecent      int
+	logFile         string
+	noLogFile       bool
+}
+
+// progress returns where per-item progress messages should be written
+func (o *options) progress() io.Writer {
+	if o.quiet {
+		return io.Discard
+	}
+	return os.Stdout
+}
+
+// stringsValue is a repeatable string flag
+type stringsValue []string
+
+func (s *stringsValue) String() string { return strings.Join(*s, ",") }
+
+func (s *stringsValue) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// replacingValue is a stringsValue given on the command line: its first
+// value replaces the list loaded from the config instead of adding to it
+type replacingValue struct {
+	list *stringsValue
+	set  bool
+}
+
+func (r *replacingValue) String() string {
+	if r.list == nil {
+		return ""
+	}
+	return r.list.String()
+}
+
+func (r *replacingValue) Set(v string) error {
+	if !r.set {
+		*r.list, r.set = nil, true
+	}
+	return r.list.Set(v)
+}
+
+// replaceConfiguredLists makes the repeatable flags of fs replace the lists
+// from the config rather than extend them
+func replaceConfiguredLists(fs *flag.FlagSet) {
+	fs.VisitAll(func(f *flag.Flag) {
+		if list, ok := f.Value.(*stringsValue); ok {
+			f.Value = &replacingValue{list: list}
+		}
+	})
+}
+
+// formatFlag is a boolean flag such as --json that selects an output format
+type formatFlag struct {
+	format *string
+	value  string
+}
+
+func (f *formatFlag) IsBoolFlag() bool { return true }
+
+func (f *formatFlag) String() string {
+	if f.format != nil && *f.format == f.value {
+		return "true"
+	}
+	return "false"
+}
+
+func (f *formatFlag) Set(v string) error {
+	on, err := strconv.ParseBool(v)
+	if err != nil {
+		return err
+	}
+	if on {
+		*f.format = f.value
+	} else if *f.format == f.value {
+		*f.format = ""
+	}
+	return nil
+}
+
+// command is a subcommand of the CLI
+type command struct {
+	name    string
+	args    string // positional argument synopsis
+	summary string
+	flags   func(fs *flag.FlagSet, opts *options)
+	run     func(ctx context.Context, opts *options, args []string) error
+}
+
+// commands lists the available subcommands; clean is the default
+var commands = []*command{
+	{
+		name:    "scan",
+		args:    "[root...]",
+		summary: "list node_modules directories without deleting anything",
+		flags: func(fs *flag.FlagSet, opts *options) {
+			opts.registerScanFlags(fs)
+			fs.Var(&formatFlag{&opts.format, "json"}, "json", "print scan results as JSON")
+			fs.Var(&formatFlag{&opts.format, "csv"}, "csv", "print scan results as CSV")
+			fs.Var(&formatFlag{&opts.format, "yaml"}, "yaml", "print scan results as YAML")
+			fs.StringVar(&opts.output, "output", "", "write scan results to this file (format inferred from a .json, .csv or .yaml extension)")
+		},
+		run: runScan,
+	},
+	{
+		name:    "clean",
+		args:    "[root...]",
+		summary: "select and delete node_modules directories (default)",
+		flags: func(fs *flag.FlagSet, opts *options) {
+			opts.registerScanFlags(fs)
+			fs.BoolVar(&opts.dryRun, "dry-run", false, "show what would be deleted without deleting anything")
+			fs.BoolVar(&opts.allowRoot, "allow-root", false, "run even as root or Administrator")
+			fs.BoolVar(&opts.yes, "yes", false, "delete all found directories without prompting")
+			fs.BoolVar(&opts.yes, "y", false, "shorthand for --yes")
+			fs.Var(&opts.free, "free", "select the oldest projects until this much space would be freed (e.g. 20GB), then confirm once")
+			fs.BoolVar(&opts.selectAll, "select-all", false, "start the selection with every directory checked")
+			fs.IntVar(&opts.recentDays, "recent-days", defaultRecentDays, "ask again before deleting in projects changed within this many days (0 to never ask)")
+			fs.BoolVar(&opts.allowRecent, "allow-recent", false, "delete in recently changed projects without asking again")
+			fs.BoolVar(&opts.allowInUse, "allow-in-use", false, "delete directories running processes have files open in without asking again")
+			fs.BoolVar(&opts.workspaceUnits, "workspace-units", false, "offer the node_modules of a monorepo workspace as one entry that deletes them all")
+			fs.BoolVar(&opts.trash, "trash", false, "move directories to the trash instead of deleting them")
+			opts.confirmSize, opts.confirmTotal = defaultConfirmSize, defaultConfirmTotal
+			fs.Var(&opts.confirmSize, "confirm-size", "require typing the project name to delete a directory larger than this (0 to never)")
+			fs.Var(&opts.confirmTotal, "confirm-total", "require typing a project name to delete more than this altogether (0 to never)")
+			fs.BoolVar(&opts.archive, "archive", false, "pack each directory into a .tar.zst before deleting it, to bring back with restore")
+			fs.StringVar(&opts.archiveDir, "archive-dir", opts.archiveDir, "where --archive puts archives (default: $XDG_STATE_HOME/clean-modules/archives)")
+			fs.StringVar(&opts.backup, "backup", "", "pack each directory into a .tar.zst in this directory before deleting it, checksummed in the audit log (restore with restore --archive-dir)")
+			fs.BoolVar(&opts.reinstall, "reinstall", false, "after deleting, reinstall each project from its lockfile (npm ci, yarn install --immutable, pnpm install --frozen-lockfile)")
+			fs.BoolVar(&opts.prunePnpm, "prune-pnpm-store", false, "run pnpm store prune after deleting, dropping store entries no project uses")
+			fs.StringVar(&opts.logFile, "log-file", "", "append a JSON line per deletion to this file (default: $XDG_STATE_HOME/clean-modules/audit.log)")
+			fs.BoolVar(&opts.noLogFile, "no-log-file", false, "don't write the deletion audit log")
+			fs.StringVar(&opts.report, "report", "", "write a full report of the run to this file (JSON for a .json extension)")
+			fs.BoolVar(&opts.wait, "wait", false, "wait for another running clean-modules to finish instead of failing")
+		},
+		run: runClean,
+	},
+	{
+		name:    "stats",
+		args:    "[root...]",
+		summary: "summarize node_modules disk usage",
+		flags: func(fs *flag.FlagSet, opts *options) {
+			opts.registerScanFlags(fs)
+		},
+		run: runStats,
+	},
+	{
+		name:    "config",
+		summary: "show the effective settings",
+		flags: func(fs *flag.FlagSet, opts *options) {
+			opts.registerScanFlags(fs)
+		},
+		run: runConfig,
+	},
+	{
+		name:    "undo",
+		summary: "restore what the last clean moved to the trash or archived",
+		flags: func(fs *flag.FlagSet, opts *options) {
+			fs.BoolVar(&opts.dryRun, "dry-run", false, "show what would be restored without restoring anything")
+			fs.BoolVar(&opts.quiet, "quiet", false, "suppress progress output")
+			fs.StringVar(&opts.logFile, "log-file", "", "audit log to read the last run from (default: $XDG_STATE_HOME/clean-modules/audit.log)")
+			fs.BoolVar(&opts.wait, "wait", false, "wait for another running clean-modules to finish instead of failing")
+		},
+		run: runUndo,
+	},
+	{
+		name:    "pin",
+		args:    "[project...]",
+		summary: "mark projects as active so they are never offered, or list the pinned ones",
+		flags:   func(*flag.FlagSet, *options) {},
+		run:     runPin,
+	},
+	{
+		name:    "unpin",
+		args:    "project...",
+		summary: "offer the directories of pinned projects again",
+		flags:   func(*flag.FlagSet, *options) {},
+		run:     runUnpin,
+	},
+	{
+		name:    "resume",
+		summary: "continue a clean run that was interrupted before it finished",
+		flags: func(fs *flag.FlagSet, opts *options) {
+			fs.BoolVar(&opts.dryRun, "dry-run", false, "show what is left to delete without deleting anything")
+			fs.BoolVar(&opts.allowRoot, "allow-root", false, "run even as root or Administrator")
+			fs.BoolVar(&opts.yes, "yes", false, "continue without prompting")
+			fs.BoolVar(&opts.yes, "y", false, "shorthand for --yes")
+			fs.BoolVar(&opts.quiet, "quiet", false, "suppress progress output and print only a final summary")
+			fs.IntVar(&opts.workers, "workers", runtime.NumCPU(), "number of directories deleted concurrently")
+			fs.StringVar(&opts.logFile, "log-file", "", "append a JSON line per deletion to this file (default: $XDG_STATE_HOME/clean-modules/audit.log)")
+			fs.BoolVar(&opts.noLogFile, "no-log-file", false, "don't write the deletion audit log")
+			fs.BoolVar(&opts.wait, "wait", false, "wait for another running clean-modules to finish instead of failing")
+		},
+		run: runResume,
+	},
+	{
+		name:    "restore",
+		args:    "[path...]",
+		summary: "unpack directories archived by clean --archive, or list the archives",
+		flags: func(fs *flag.FlagSet, opts *options) {
+			fs.StringVar(&opts.archiveDir, "archive-dir", opts.archiveDir, "directory holding the archives (default: $XDG_STATE_HOME/clean-modules/archives)")
+			fs.BoolVar(&opts.quiet, "quiet", false, "suppress progress output")
+			fs.StringVar(&opts.sizeFormat, "size-format", sizeBinary, "how to display sizes: binary (1024-based), si (1000-based) or bytes")
+			fs.BoolVar(&opts.wait, "wait", false, "wait for another running clean-modules to finish instead of failing")
+		},
+		run: runRestore,
+	},
+	{
+		name:    "prune",
+		args:    "[project...]",
+		summary: "remove only some packages from node_modules: devDependencies or extraneous ones",
+		flags: func(fs *flag.FlagSet, opts *options) {
+			fs.BoolVar(&opts.pruneDev, "dev", false, "remove the packages only devDependencies need, keeping what the project needs to run")
+			fs.BoolVar(&opts.pruneExtraneous, "extraneous", false, "remove the packages the lockfile doesn't list, like npm prune")
+			fs.BoolVar(&opts.dryRun, "dry-run", false, "show what would be removed without removing anything")
+			fs.BoolVar(&opts.allowRoot, "allow-root", false, "run even as root or Administrator")
+			fs.BoolVar(&opts.trash, "trash", false, "move directories to the trash instead of deleting them")
+			fs.BoolVar(&opts.yes, "yes", false, "don't ask for confirmation")
+			fs.BoolVar(&opts.yes, "y", false, "shorthand for --yes")
+			fs.BoolVar(&opts.quiet, "quiet", false, "suppress progress output")
+			fs.BoolVar(&opts.diskUsage, "disk-usage", false, "measure allocated disk space like du instead of summing file sizes")
+			fs.IntVar(&opts.workers, "workers", runtime.NumCPU(), "number of packages removed concurrently")
+			fs.StringVar(&opts.logFile, "log-file", "", "append a JSON line per removal to this file (default: $XDG_STATE_HOME/clean-modules/audit.log)")
+			fs.BoolVar(&opts.noLogFile, "no-log-file", false, "don't write the deletion audit log")
+			fs.BoolVar(&opts.wait, "wait", false, "wait for another running clean-modules to finish instead of failing")
+		},
+		run: runPrune,
+	},
+	{
+		name:    "dedupe-report",
+		args:    "[root...]",
+		summary: "report package versions installed in several node_modules",
+		flags: func(fs *flag.FlagSet, opts *options) {
+			opts.registerScanFlags(fs)
+			fs.Var(&formatFlag{&opts.format, "json"}, "json", "print every duplicate and its copies as JSON")
+			fs.IntVar(&opts.duplicates, "duplicates", 20, "only list the N most wasteful duplicates (0 for all)")
+		},
+		run: runDedupeReport,
+	},
+	{
+		name:    "dedupe",
+		args:    "[root...]",
+		summary: "hard-link identical files of duplicate packages instead of deleting them",
+		flags: func(fs *flag.FlagSet, opts *options) {
+			opts.registerScanFlags(fs)
+			fs.BoolVar(&opts.dryRun, "dry-run", false, "show how much would be freed without changing anything")
+			fs.BoolVar(&opts.allowRoot, "allow-root", false, "run even as root or Administrator")
+			fs.BoolVar(&opts.yes, "yes", false, "don't ask for confirmation")
+			fs.BoolVar(&opts.yes, "y", false, "shorthand for --yes")
+			fs.BoolVar(&opts.reflink, "reflink", false, "use copy-on-write clones instead of hard links (Btrfs, XFS; Linux only)")
+			fs.BoolVar(&opts.undo, "undo", false, "give every file linked by earlier runs its own copy again")
+			fs.BoolVar(&opts.wait, "wait", false, "wait for another running clean-modules to finish instead of failing")
+		},
+		run: runDedupe,
+	},
+	{
+		name:    "packages",
+		args:    "[node_modules or project...]",
+		summary: "list the largest packages inside node_modules",
+		flags: func(fs *flag.FlagSet, opts *options) {
+			fs.IntVar(&opts.top, "top", 20, "only show the N largest packages (0 for all)")
+			fs.Var(&formatFlag{&opts.format, "json"}, "json", "print the packages as JSON")
+			fs.BoolVar(&opts.diskUsage, "disk-usage", false, "measure allocated disk space like du instead of summing file sizes")
+			fs.IntVar(&opts.workers, "workers", runtime.NumCPU(), "number of packages sized concurrently")
+			fs.StringVar(&opts.sizeFormat, "size-format", sizeBinary, "how to display sizes: binary (1024-based), si (1000-based) or bytes")
+			fs.StringVar(&opts.color, "color", colorAuto, "when to color output: auto, always or never (auto honors NO_COLOR)")
+		},
+		run: runPackages,
+	},
+	{
+		name:    "caches",
+		summary: "list and clear global package manager and build caches",
+		flags: func(fs *flag.FlagSet, opts *options) {
+			fs.Var(&opts.detectors, "detector", "only show the caches of this detector, e.g. yarn (repeatable)")
+			fs.Var(&opts.categories, "category", "only show the caches of this detector category (repeatable)")
+			fs.BoolVar(&opts.clear, "clear", false, "select caches to delete after listing them")
+			fs.BoolVar(&opts.trash, "trash", false, "move directories to the trash instead of deleting them")
+			opts.confirmSize, opts.confirmTotal = defaultConfirmSize, defaultConfirmTotal
+			fs.Var(&opts.confirmSize, "confirm-size", "require typing the project name to delete a directory larger than this (0 to never)")
+			fs.Var(&opts.confirmTotal, "confirm-total", "require typing a project name to delete more than this altogether (0 to never)")
+			fs.BoolVar(&opts.yes, "yes", false, "with --clear, delete every listed cache without prompting")
+			fs.BoolVar(&opts.yes, "y", false, "shorthand for --yes")
+			fs.BoolVar(&opts.selectAll, "select-all", false, "start the selection with every cache checked")
+			fs.BoolVar(&opts.dryRun, "dry-run", false, "show what --clear would delete without deleting")
+			fs.BoolVar(&opts.allowRoot, "allow-root", false, "run even as root or Administrator")
+			fs.BoolVar(&opts.diskUsage, "disk-usage", false, "measure allocated disk space like du instead of summing file sizes")
+			fs.BoolVar(&opts.showErrors, "show-errors", false, "list every path that couldn't be read instead of only counting them")
+			fs.BoolVar(&opts.quiet, "quiet", false, "suppress progress output")
+			fs.IntVar(&opts.workers, "workers", runtime.NumCPU(), "number of caches deleted concurrently")
+			fs.StringVar(&opts.logFile, "log-file", "", "append a JSON line per deletion to this file (default: $XDG_STATE_HOME/clean-modules/audit.log)")
+			fs.BoolVar(&opts.noLogFile, "no-log-file", false, "don't write the deletion audit log")
+			fs.StringVar(&opts.sizeFormat, "size-format", sizeBinary, "how to display sizes: binary (1024-based), si (1000-based) or bytes")
+			fs.StringVar(&opts.color, "color", colorAuto, "when to color output: auto, always or never (auto honors NO_COLOR)")
+			fs.BoolVar(&opts.wait, "wait", false, "wait for another running clean-modules to finish instead of failing")
+		},
+		run: runCaches,
+	},
+	{
+		name:    "docker",
+		summary: "report and prune dangling images, stopped containers and unused volumes",
+		flags: func(fs *flag.FlagSet, opts *options) {
+			fs.Var(&formatFlag{&opts.format, "json"}, "json", "print the reclaimable objects as JSON")
+			fs.BoolVar(&opts.prune, "prune", false, "remove the reported objects after confirmation")
+			fs.BoolVar(&opts.yes, "yes", false, "don't ask for confirmation before pruning")
+			fs.BoolVar(&opts.yes, "y", false, "shorthand for --yes")
+			fs.BoolVar(&opts.dryRun, "dry-run", false, "only report, even with --prune")
+			fs.BoolVar(&opts.quiet, "quiet", false, "suppress progress output")
+			fs.StringVar(&opts.sizeFormat, "size-format", sizeBinary, "how to display sizes: binary (1024-based), si (1000-based) or bytes")
+			fs.StringVar(&opts.color, "color", colorAuto, "when to color output: auto, always or never (auto honors NO_COLOR)")
+		},
+		run: runDocker,
+	},
+	{
+		name:    "version",
+		summary: "print version and build information",
+		flags:   func(*flag.FlagSet, *options) {},
+		run:     runVersion,
+	},
+}
+
+func init() {
+	// Registered here because generating completions needs the full command list
+	commands = append(commands, &command{
+		name:    "completion",
+		args:    "bash|zsh|fish|powershell",
+		summary: "print a shell completion script",
+		flags:   func(*flag.FlagSet, *options) {},
+		run:     runCompletion,
+	})
+}
+
+// findCommand returns the command with the given name, or nil
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// registerScanFlags adds the flags shared by every command that scans
+func (o *options) registerScanFlags(fs *flag.FlagSet) {
+	fs.Var(&o.olderThan, "older-than", "only show projects untouched for this long (e.g. 90d, 2w, 6m)")
+	fs.Var(&o.minSize, "min-size", "only show directories at least this large (e.g. 100MB)")
+	fs.StringVar(&o.profile, "profile", "", "use a named profile of names, filters and safety rules (js, python, rust, everything or one from the config)")
+	fs.Var(&o.detectors, "detector", "look for the directories of this detector, e.g. node_modules or rust (repeatable)")
+	fs.Var(&o.categories, "category", "look for every detector of this category, e.g. dependencies or build-artifacts (repeatable)")
+	fs.Var(&o.names, "name", "directory name or glob to look for instead of node_modules (repeatable, e.g. .yarn/cache)")
+	fs.Var(&o.excludes, "exclude", "skip paths matching this glob (repeatable, supports **)")
+	fs.Var(&o.includes, "include", "only scan paths matching this glob (repeatable, supports **)")
+	fs.IntVar(&o.maxDepth, "max-depth", 0, "maximum directory depth to scan below the root (0 for unlimited)")
+	fs.IntVar(&o.top, "top", 0, "only show the N largest directories")
+	fs.IntVar(&o.keepRecent, "keep-recent", 0, "never include the N most recently active projects, regardless of other filters")
+	fs.StringVar(&o.sortBy, "sort", "size", "order results by size, path or age")
+	fs.BoolVar(&o.reverse, "reverse", false, "reverse the sort order")
+	fs.IntVar(&o.workers, "workers", runtime.NumCPU(), "number of directories read, sized or deleted concurrently")
+	fs.BoolVar(&o.quiet, "quiet", false, "suppress progress output and print only a final summary")
+	fs.BoolVar(&o.verbose, "verbose", false, "log skipped paths, errors and timings to stderr")
+	fs.BoolVar(&o.debug, "debug", false, "log every visited candidate in addition to --verbose output")
+	fs.BoolVar(&o.globalCaches, "global-caches", false, "also offer the machine-wide caches of the selected detectors, e.g. ~/.gradle/caches")
+	fs.BoolVar(&o.showErrors, "show-errors", false, "list every path that couldn't be read instead of only counting them")
+	fs.BoolVar(&o.skipHidden, "skip-hidden", false, "don't descend into dot-directories like .cache or .Trash")
+	fs.BoolVar(&o.allowOrphans, "allow-orphans", false, "also report node_modules directories without a package.json next to them, e.g. in backups or test fixtures")
+	fs.BoolVar(&o.oneFileSystem, "one-file-system", false, "don't descend into directories on other filesystems, such as network mounts")
+	fs.BoolVar(&o.followSymlinks, "follow-symlinks", false, "traverse symlinked directories while scanning (symlinked node_modules are always skipped)")
+	fs.BoolVar(&o.noSize, "no-size", false, "list directories without computing their sizes (faster on slow filesystems)")
+	fs.BoolVar(&o.diskUsage, "disk-usage", false, "measure allocated disk space like du instead of summing file sizes")
+	fs.StringVar(&o.sizeFormat, "size-format", sizeBinary, "how to display sizes: binary (1024-based), si (1000-based) or bytes")
+	fs.StringVar(&o.color, "color", colorAuto, "when to color output: auto, always or never (auto honors NO_COLOR)")
+	fs.DurationVar(&o.timeout, "timeout", 0, "stop scanning after this long and continue with partial results (e.g. 5m)")
+	fs.BoolVar(&o.stdin, "stdin", false, "read directories to consider from stdin, one per line, instead of scanning")
+}
+
+// newScanOptions validates the discovery settings in opts
+func newScanOptions(opts *options) (scanOptions, error) {
+	if opts.workers < 1 {
+		return scanOptions{}, fmt.Errorf("--workers must be at least 1")
+	}
+
+	var err error
+	scan := scanOptions{
+		maxDepth:       opts.maxDepth,
+		workers:        opts.workers,
+		followSymlinks: opts.followSymlinks,
+		skipSize:       opts.noSize,
+		diskUsage:      opts.diskUsage,
+		oneFileSystem:  opts.oneFileSystem,
+		skipHidden:     opts.skipHidden,
+		allowOrphans:   opts.allowOrphans,
+		errors:         &walkErrors{},
+	}
+	if scan.detectors, err = selectDetectors(opts.names, opts.detectors, opts.categories); err != nil {
+		return scan, err
+	}
+	if scan.targets, err = compileTargets(opts.names, scan.detectors); err != nil {
+		return scan, err
+	}
+	if scan.excludes, err = compileGlobs(opts.excludes); err != nil {
+		return scan, fmt.Errorf("parsing --exclude: %w", err)
+	}
+	if scan.includes, err = compileGlobs(opts.includes); err != nil {
+		return scan, fmt.Errorf("parsing --include: %w", err)
+	}
+	return scan, nil
+}
+
+// scanDirectories scans the roots given in args (or the configured roots, or
+// the working directory), then filters and sorts the results. It returns a
+// description of the scanned roots for messages. Progress goes to status.
+func scanDirectories(ctx context.Context, opts *options, args []string, status io.Writer) (string, []Directory, error) {
+	scan, err := newScanOptions(opts)
+	if err != nil {
+		return "", nil, err
+	}
+	filters, err := newFilterSet(opts)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+
+	where, dirs, err := discoverDirectories(ctx, opts, scan, args, status)
+	scan.errors.report(os.Stderr, opts.showErrors)
+	if errors.Is(err, context.DeadlineExceeded) {
+		printWarning("scan timed out after %s, showing partial results", opts.timeout)
+	} else if err != nil {
+		return "", nil, err
+	}
+
+	start := time.Now()
+	dirs = filters.apply(uniqueDirectories(dirs))
+	slog.Info("filtering finished", "kept", len(dirs), "duration", time.Since(start).Round(time.Millisecond))
+	return where, dirs, nil
+}
+
+// discoverDirectories finds candidates on stdin or below the scan roots. When
+// ctx ends early it returns what was found so far along with ctx's error.
+func discoverDirectories(ctx context.Context, opts *options, scan scanOptions, args []string, status io.Writer) (string, []Directory, error) {
+	if opts.stdin {
+		fmt.Fprintln(status, "Reading directories from stdin...")
+		dirs, err := readDirectories(ctx, os.Stdin, scan)
+		if err != nil && ctx.Err() == nil {
+			return "", nil, fmt.Errorf("reading stdin: %w", err)
+		}
+		return "stdin", dirs, ctx.Err()
+	}
+
+	roots := args
+	if len(roots) == 0 {
+		roots = opts.roots
+	}
+	if len(roots) == 0 {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", nil, fmt.Errorf("getting current directory: %w", err)
+		}
+		roots = []string{wd}
+	}
+
+	var dirs []Directory
+	where := strings.Join(roots, ", ")
+	for _, root := range normalizeRoots(roots) {
+		fmt.Fprintf(status, "Scanning for %s in %s (this may take a moment)...\n", scan.targets, root)
+
+		// Find all node_modules directories with their sizes
+		found, err := findNodeModules(ctx, root, scan)
+		dirs = append(dirs, found...)
+		if ctx.Err() != nil {
+			return where, dirs, ctx.Err()
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("walking directory: %w", err)
+		}
+	}
+
+	if opts.globalCaches {
+		fmt.Fprintln(status, "Sizing global caches...")
+		dirs = append(dirs, globalDirectories(ctx, scan.detectors, scan)...)
+	}
+	return where, dirs, ctx.Err()
+}
+
+// runScan lists the found directories
+func runScan(ctx context.Context, opts *options, args []string) error {
+	format := opts.format
+	if format == "" {
+		format = formatFromPath(opts.output)
+	}
+	write, ok := outputFormats[format]
+	if !ok {
+		return fmt.Errorf("unknown output format %q", format)
+	}
+
+	// Keep stdout clean for machine-readable output
+	status := opts.progress()
+	if format != formatText && opts.output == "" && !opts.quiet {
+		status = os.Stderr
+	}
+
+	root, dirs, err := scanDirectories(ctx, opts, args, status)
+	if err != nil {
+		return err
+	}
+	if format == formatJSON || format == formatYAML {
+		checkRestorable(ctx, dirs, opts.workers) // part of the records
+	}
+
+	if len(dirs) == 0 && format == formatText {
+		fmt.Fprintf(opts.progress(), "No matching directories found in %s\n", root)
+		return errNothingFound
+	}
+
+	out := os.Stdout
+	if opts.output != "" {
+		if out, err = os.Create(opts.output); err != nil {
+			return fmt.Errorf("creating output file: %w", err)
+		}
+		defer out.Close()
+	}
+	if err := write(out, dirs); err != nil {
+		return fmt.Errorf("writing %s output: %w", format, err)
+	}
+	if opts.output != "" {
+		if err := out.Close(); err != nil {
+			return fmt.Errorf("writing output file: %w", err)
+		}
+		fmt.Fprintf(status, "Wrote %d entries to %s\n", len(dirs), opts.output)
+	}
+
+	if len(dirs) == 0 {
+		return errNothingFound
+	}
+	return nil
+}
+
+// runClean selects, confirms and deletes directories
+func runClean(ctx context.Context, opts *options, args []string) (err error) {
+	if opts.free > 0 && opts.noSize {
+		return fmt.Errorf("--free needs directory sizes and can't be combined with --no-size")
+	}
+	if err := guardRoot(opts); err != nil {
+		return err
+	}
+	unlock, err := lockRun(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if opts.backup != "" {
+		// A backup is an archive kept where the user chose
+		opts.archive, opts.archiveDir = true, opts.backup
+	}
+
+	report := newRunReport(opts.dryRun)
+	if opts.report != "" {
+		defer func() {
+			report.finish(err)
+			if werr := report.write(opts.report); werr != nil {
+				printWarning("could not write report: %v", werr)
+			}
+		}()
+	}
+
+	progress := opts.progress()
+	root, dirs, err := scanDirectories(ctx, opts, args, progress)
+	report.Roots = root
+	report.ScanDuration = time.Since(report.Started).Round(time.Millisecond).String()
+	if err == nil && !opts.yes && opts.free == 0 {
+		checkRestorable(ctx, dirs, opts.workers) // shown in the selection list
+	}
+	report.Found = newDirRecords(dirs)
+	if err != nil {
+		return err
+	}
+
+	if len(dirs) == 0 {
+		fmt.Fprintf(progress, "No matching directories found in %s\n", root)
+		return errNothingFound
+	}
+
+	var selected []Directory
+	switch {
+	case opts.free > 0:
+		selected = untilFreed(dirs, int64(opts.free))
+		if freed := sumUnique(selected); freed < int64(opts.free) {
+			printWarning("all %d directories together only free %s of the requested %s", len(selected), formatSize(freed), formatSize(int64(opts.free)))
+		} else {
+			fmt.Fprintf(progress, "Selected %d directories to free %s (target %s)\n", len(selected), formatSize(freed), formatSize(int64(opts.free)))
+		}
+	case opts.yes:
+		selected = dirs
+	default:
+		selected, err = selectDirectories(dirs, opts.selectAll, opts.workspaceUnits)
+		if err != nil {
+			return fmt.Errorf("during selection: %w", err)
+		}
+	}
+
+	if selected, err = guardProtected(selected, opts); err != nil {
+		return err
+	}
+	if selected, err = guardRecent(selected, opts); err != nil {
+		return err
+	}
+	if selected, err = guardInUse(selected, opts); err != nil {
+		return err
+	}
+	warnSynced(selected)
+	report.Selected = newDirRecords(selected)
+	if len(selected) == 0 {
+		fmt.Fprintln(progress, "No directories selected for deletion.")
+		return errAborted
+	}
+
+	// Calculate total size to be deleted
+	totalSize := totalSizeString(selected)
+
+	if opts.dryRun {
+		if opts.quiet {
+			fmt.Printf("Would delete %d directories (%s)\n", len(selected), totalSize)
+		} else {
+			printDryRun(os.Stdout, selected, totalSize)
+		}
+		return nil
+	}
+
+	// Confirm deletion with total size
+	if !opts.yes {
+		confirm, err := confirmDeletion(selected, totalSize, opts)
+		if err != nil {
+			return fmt.Errorf("during confirmation: %w", err)
+		}
+		if !confirm {
+			fmt.Fprintln(progress, "Operation cancelled.")
+			return errAborted
+		}
+	}
+
+	if plan, _ := loadPlan(); plan != nil {
+		printWarning("the interrupted run of %s is given up, its %d directories are no longer resumable", plan.Started.Format("2006-01-02 15:04"), len(plan.Dirs))
+	}
+	summary, err := carryOut(ctx, opts, selected, progress)
+	report.addDeletions(summary)
+	return err
+}
+
+// carryOut archives, deletes and reinstalls the selected directories as opts
+// say. The plan is kept on disk while it runs, for resume to pick up an
+// interrupted run.
+func carryOut(ctx context.Context, opts *options, selected []Directory, progress io.Writer) (deleteSummary, error) {
+	selected, denied := checkPermissions(selected)
+	if len(selected) == 0 {
+		return deleteSummary{}, fmt.Errorf("none of the selected directories can be deleted, nothing was deleted")
+	}
+	if err := savePlan(opts, selected); err != nil {
+		printWarning("saving the deletion plan failed, resume won't be able to continue this run: %v", err)
+	}
+
+	archiveFailed := 0
+	if opts.archive {
+		store, err := openArchiveStore(opts.archiveDir)
+		if err != nil {
+			return deleteSummary{}, fmt.Errorf("opening archive directory: %w", err)
+		}
+		fmt.Fprintf(progress, "\nArchiving %d directories to %s ⏳\n", len(selected), store.dir)
+		if selected, archiveFailed = archiveDirectories(ctx, selected, opts.workers, store, progress); ctx.Err() != nil {
+			return deleteSummary{}, &codeError{code: exitAborted, err: fmt.Errorf("interrupted while archiving, nothing was deleted")}
+		}
+		if len(selected) == 0 {
+			return deleteSummary{}, fmt.Errorf("no directory could be archived, nothing was deleted")
+		}
+	}
+
+	verb := "Deleting"
+	if opts.trash {
+		verb = "Moving to trash"
+	}
+	fmt.Fprintf(progress, "\n%s %d directories (total size: %s) ⏳\n", verb, len(selected), paintSize(progress, sumSizes(selected), totalSizeString(selected)))
+	audit, err := openAudit(opts)
+	if err != nil {
+		printWarning("audit log disabled: %v", err)
+	}
+	defer audit.Close()
+	summary := deleteDirectories(ctx, selected, opts.workers, opts.trash, progress, audit)
+	if err := summary.interrupted(); err != nil {
+		fmt.Fprintln(os.Stderr, "Run 'clean-modules resume' to delete the rest.")
+		return summary, err
+	}
+	if err := removePlan(); err != nil {
+		printWarning("removing the deletion plan failed: %v", err)
+	}
+	if opts.prunePnpm {
+		if err := prunePnpmStore(ctx, progress); err != nil {
+			printWarning("%v", err)
+		}
+	}
+	reinstallFailed := 0
+	if opts.reinstall {
+		reinstallFailed = reinstallProjects(ctx, summary.results, progress)
+	}
+	if opts.quiet {
+		freed := formatSize(summary.freed)
+		if opts.noSize {
+			freed = "unknown size"
+		}
+		fmt.Printf("Deleted %d directories (%s freed), %d failed\n", summary.deleted, freed, summary.failed)
+	} else {
+		fmt.Printf("\n%s\n", paint(os.Stdout, ansiGreen, "Operation completed! 🎉"))
+	}
+
+	if failed := summary.failed + denied; failed > 0 {
+		return summary, &codeError{
+			code: exitPartialFailure,
+			err:  fmt.Errorf("%d of %d directories could not be deleted", failed, len(selected)+denied),
+		}
+	}
+	if archiveFailed > 0 {
+		return summary, &codeError{
+			code: exitPartialFailure,
+			err:  fmt.Errorf("%d directories could not be archived and were kept", archiveFailed),
+		}
+	}
+	if reinstallFailed > 0 {
+		return summary, &codeError{
+			code: exitPartialFailure,
+			err:  fmt.Errorf("%d projects could not be reinstalled", reinstallFailed),
+		}
+	}
+	return summary, nil
+}
+
+// openAudit opens the deletion audit log selected by --log-file, or nil when
+// it is disabled with --no-log-file
+func openAudit(opts *options) (*auditLog, error) {
+	if opts.noLogFile {
+		return nil, nil
+	}
+	path := opts.logFile
+	if path == "" {
+		var err error
+		if path, err = auditLogPath(); err != nil {
+			return nil, err
+		}
+	}
+	return openAuditLog(path)
+}
+
+// runStats prints a summary of disk usage
+func runStats(ctx context.Context, opts *options, args []string) error {
+	if opts.noSize {
+		return fmt.Errorf("stats needs directory sizes and can't be combined with --no-size")
+	}
+
+	root, dirs, err := scanDirectories(ctx, opts, args, opts.progress())
+	if err != nil {
+		return err
+	}
+
+	if len(dirs) == 0 {
+		fmt.Fprintf(opts.progress(), "No matching directories found in %s\n", root)
+		return errNothingFound
+	}
+	writeStats(os.Stdout, dirs)
+	return nil
+}
+
+// runConfig prints the config file location and the settings in effect
+// after applying flags
+func runConfig(_ context.Context, opts *options, _ []string) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("# Config file: %s\n", path)
+
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(effectiveConfig(opts)); err != nil {
+		return err
+	}
+	return enc.Close()
+}
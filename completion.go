@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// binaryNames are the names the tool is installed under
+var binaryNames = []string{"clean-modules", "drop-modules"}
+
+// flagSpec describes a flag for completion scripts
+type flagSpec struct {
+	name   string
+	usage  string
+	isBool bool
+}
+
+// commandSpec describes a command and its flags for completion scripts
+type commandSpec struct {
+	name    string
+	summary string
+	flags   []flagSpec
+}
+
+// completionSpecs builds completion data from the command definitions
+func completionSpecs() []commandSpec {
+	var specs []commandSpec
+	for _, cmd := range commands {
+		fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+		cmd.flags(fs, &options{})
+
+		spec := commandSpec{name: cmd.name, summary: cmd.summary}
+		fs.VisitAll(func(f *flag.Flag) {
+			b, ok := f.Value.(interface{ IsBoolFlag() bool })
+			spec.flags = append(spec.flags, flagSpec{
+				name:   f.Name,
+				usage:  f.Usage,
+				isBool: ok && b.IsBoolFlag(),
+			})
+		})
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// dashed returns how the flag is written on the command line
+func (f flagSpec) dashed() string {
+	if len(f.name) == 1 {
+		return "-" + f.name
+	}
+	return "--" + f.name
+}
+
+// flagWords returns every flag of spec as typed on the command line
+func (spec commandSpec) flagWords() string {
+	words := make([]string, 0, len(spec.flags))
+	for _, f := range spec.flags {
+		words = append(words, f.dashed())
+	}
+	return strings.Join(words, " ")
+}
+
+// completionShells maps shell names to their script generators
+var completionShells = map[string]func(w io.Writer, specs []commandSpec){
+	"bash":       writeBashCompletion,
+	"zsh":        writeZshCompletion,
+	"fish":       writeFishCompletion,
+	"powershell": writePowerShellCompletion,
+}
+
+// runCompletion prints the completion script for the requested shell
+func runCompletion(_ context.Context, _ *options, args []string) error {
+	var shells []string
+	for name := range completionShells {
+		shells = append(shells, name)
+	}
+	sort.Strings(shells)
+
+	if len(args) != 1 {
+		return fmt.Errorf("expected one shell: %s", strings.Join(shells, ", "))
+	}
+	generate, ok := completionShells[args[0]]
+	if !ok {
+		return fmt.Errorf("unsupported shell %q (use %s)", args[0], strings.Join(shells, ", "))
+	}
+	generate(os.Stdout, completionSpecs())
+	return nil
+}
+
+func writeBashCompletion(w io.Writer, specs []commandSpec) {
+	var names []string
+	for _, spec := range specs {
+		names = append(names, spec.name)
+	}
+
+	fmt.Fprintf(w, `# bash completion for clean-modules
+_clean_modules() {
+    local cur="${COMP_WORDS[COMP_CWORD]}" cmd="" flags="" i
+    for ((i = 1; i < COMP_CWORD; i++)); do
+        case "${COMP_WORDS[i]}" in
+            %s) cmd="${COMP_WORDS[i]}"; break ;;
+        esac
+    done
+
+    case "$cmd" in
+`, strings.Join(names, "|"))
+	for _, spec := range specs {
+		pattern := spec.name
+		if spec.name == "clean" {
+			pattern = `clean|""` // the default command
+		}
+		fmt.Fprintf(w, "        %s) flags=%q ;;\n", pattern, spec.flagWords())
+	}
+	fmt.Fprintf(w, `    esac
+
+    if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W "$flags" -- "$cur"))
+    elif [[ -z "$cmd" ]]; then
+        COMPREPLY=($(compgen -W %q -- "$cur") $(compgen -d -- "$cur"))
+    else
+        COMPREPLY=($(compgen -d -- "$cur"))
+    fi
+}
+complete -o filenames -F _clean_modules %s
+`, strings.Join(names, " "), strings.Join(binaryNames, " "))
+}
+
+func writeZshCompletion(w io.Writer, specs []commandSpec) {
+	// zshQuote escapes text for use inside a single-quoted _arguments spec
+	zshQuote := strings.NewReplacer(`'`, `'\''`, "[", `\[`, "]", `\]`, ":", `\:`).Replace
+
+	var names []string
+	fmt.Fprintf(w, "#compdef %s\n\n_clean_modules() {\n    local -a cmds\n    cmds=(\n", strings.Join(binaryNames, " "))
+	for _, spec := range specs {
+		names = append(names, spec.name)
+		fmt.Fprintf(w, "        '%s:%s'\n", spec.name, zshQuote(spec.summary))
+	}
+	fmt.Fprintf(w, `    )
+
+    local cmd=clean i
+    for (( i = 2; i < CURRENT; i++ )); do
+        case $words[i] in
+            %s) cmd=$words[i]; break ;;
+        esac
+    done
+
+    case $cmd in
+`, strings.Join(names, "|"))
+	for _, spec := range specs {
+		fmt.Fprintf(w, "        %s)\n            _arguments -s \\\n", spec.name)
+		for _, f := range spec.flags {
+			value := ":value:"
+			if f.isBool {
+				value = ""
+			}
+			fmt.Fprintf(w, "                '%s[%s]%s' \\\n", f.dashed(), zshQuote(f.usage), value)
+		}
+		fmt.Fprintf(w, "                '*:root:_files -/'\n            ;;\n")
+	}
+	fmt.Fprintf(w, `    esac
+
+    if (( CURRENT == 2 )); then
+        _describe -t commands 'command' cmds
+    fi
+}
+
+compdef _clean_modules %s
+`, strings.Join(binaryNames, " "))
+}
+
+func writeFishCompletion(w io.Writer, specs []commandSpec) {
+	fishQuote := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace
+
+	var names []string
+	for _, spec := range specs {
+		names = append(names, spec.name)
+	}
+
+	fmt.Fprintln(w, "# fish completion for clean-modules")
+	for _, bin := range binaryNames {
+		fmt.Fprintf(w, "complete -c %s -f -a '(__fish_complete_directories)'\n", bin)
+		for _, spec := range specs {
+			fmt.Fprintf(w, "complete -c %s -n '__fish_use_subcommand' -a %s -d '%s'\n", bin, spec.name, fishQuote(spec.summary))
+		}
+		for _, spec := range specs {
+			condition := "__fish_seen_subcommand_from " + spec.name
+			if spec.name == "clean" {
+				// Flags of the default command apply before any subcommand too
+				condition = "not __fish_seen_subcommand_from " + strings.Join(names, " ") + "; or " + condition
+			}
+			for _, f := range spec.flags {
+				opt := "-l " + f.name
+				if len(f.name) == 1 {
+					opt = "-s " + f.name
+				}
+				if !f.isBool {
+					opt += " -r"
+				}
+				fmt.Fprintf(w, "complete -c %s -n '%s' %s -d '%s'\n", bin, condition, opt, fishQuote(f.usage))
+			}
+		}
+	}
+}
+
+func writePowerShellCompletion(w io.Writer, specs []commandSpec) {
+	psQuote := strings.NewReplacer(`'`, `''`).Replace
+
+	fmt.Fprintf(w, "# PowerShell completion for clean-modules\nRegister-ArgumentCompleter -Native -CommandName %s -ScriptBlock {\n", strings.Join(binaryNames, ","))
+	fmt.Fprintln(w, "    param($wordToComplete, $commandAst, $cursorPosition)")
+	fmt.Fprintln(w, "    $commands = [ordered]@{")
+	for _, spec := range specs {
+		fmt.Fprintf(w, "        '%s' = @{ summary = '%s'; flags = [ordered]@{\n", spec.name, psQuote(spec.summary))
+		for _, f := range spec.flags {
+			fmt.Fprintf(w, "            '%s' = '%s'\n", f.dashed(), psQuote(f.usage))
+		}
+		fmt.Fprintln(w, "        } }")
+	}
+	fmt.Fprint(w, `    }
+
+    $words = @($commandAst.CommandElements | Select-Object -Skip 1 | ForEach-Object { $_.ToString() })
+    $cmd = 'clean'
+    $explicit = $false
+    foreach ($word in $words) {
+        if ($commands.Contains($word)) { $cmd = $word; $explicit = $true; break }
+    }
+
+    if ($wordToComplete -like '-*') {
+        $flags = $commands[$cmd].flags
+        $flags.Keys | Where-Object { $_ -like "$wordToComplete*" } | ForEach-Object {
+            [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterName', $flags[$_])
+        }
+    } elseif (-not $explicit) {
+        $commands.Keys | Where-Object { $_ -like "$wordToComplete*" } | ForEach-Object {
+            [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $commands[$_].summary)
+        }
+    }
+}
+`)
+}
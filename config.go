@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is the on-disk configuration. Every field is optional and only
+// provides a default that command-line flags override.
+type fileConfig struct {
+	Roots          []string `yaml:"roots,omitempty"`
+	Detectors      []string `yaml:"detectors,omitempty"`
+	Categories     []string `yaml:"categories,omitempty"`
+	Names          []string `yaml:"names,omitempty"`
+	Exclude        []string `yaml:"exclude,omitempty"`
+	Include        []string `yaml:"include,omitempty"`
+	ProtectedPaths []string `yaml:"protected_paths,omitempty"` // never deleted, even when selected
+	Pinned         []string `yaml:"pinned,omitempty"`          // active projects, managed with pin and unpin
+	MinSize        string   `yaml:"min_size,omitempty"`
+	ConfirmSize    string   `yaml:"confirm_size,omitempty"`
+	ConfirmTotal   string   `yaml:"confirm_total,omitempty"`
+	OlderThan      string   `yaml:"older_than,omitempty"`
+	MaxDepth       int      `yaml:"max_depth,omitempty"`
+	KeepRecent     int      `yaml:"keep_recent,omitempty"`
+	Sort           string   `yaml:"sort,omitempty"`
+	Reverse        bool     `yaml:"reverse,omitempty"`
+	Concurrency    int      `yaml:"concurrency,omitempty"`
+	FollowSymlinks bool     `yaml:"follow_symlinks,omitempty"`
+	OneFileSystem  bool     `yaml:"one_file_system,omitempty"`
+	SkipHidden     bool     `yaml:"skip_hidden,omitempty"`
+	AllowOrphans   bool     `yaml:"allow_orphans,omitempty"`
+	GlobalCaches   bool     `yaml:"global_caches,omitempty"`
+	Format         string   `yaml:"format,omitempty"` // "text", "json", "csv" or "yaml"
+	SizeFormat     string   `yaml:"size_format,omitempty"`
+	DiskUsage      bool     `yaml:"disk_usage,omitempty"`
+	Color          string   `yaml:"color,omitempty"`
+	Timeout        string   `yaml:"timeout,omitempty"`
+	ArchiveDir     string   `yaml:"archive_dir,omitempty"`
+	Trash          bool     `yaml:"trash,omitempty"` // move to the trash instead of deleting
+	RecentDays     int      `yaml:"recent_days,omitempty"`
+	AllowRecent    bool     `yaml:"allow_recent,omitempty"`
+
+	Profile  string                 `yaml:"profile,omitempty"`  // profile used without --profile
+	Profiles map[string]*fileConfig `yaml:"profiles,omitempty"` // named bundles of the settings above
+
+	CustomDetectors []detectorSpec `yaml:"custom_detectors,omitempty"` // detectors beyond the built-in ones
+}
+
+// configPath returns the location of the config file, honoring
+// $CLEAN_MODULES_CONFIG and $XDG_CONFIG_HOME
+func configPath() (string, error) {
+	if p := os.Getenv("CLEAN_MODULES_CONFIG"); p != "" {
+		return p, nil
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "clean-modules", "config.yaml"), nil
+}
+
+// loadConfig reads the config file. A missing file yields an empty config.
+func loadConfig(path string) (*fileConfig, error) {
+	cfg := &fileConfig{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// apply copies the configured defaults into opts and registers custom
+// detectors
+func (c *fileConfig) apply(opts *options) error {
+	if err := registerDetectors(c.CustomDetectors); err != nil {
+		return fmt.Errorf("custom_detectors: %w", err)
+	}
+	opts.roots = append(opts.roots, c.Roots...)
+	opts.detectors = append(opts.detectors, c.Detectors...)
+	opts.categories = append(opts.categories, c.Categories...)
+	opts.names = append(opts.names, c.Names...)
+	opts.excludes = append(opts.excludes, c.Exclude...)
+	opts.includes = append(opts.includes, c.Include...)
+	opts.protected = append(opts.protected, c.ProtectedPaths...)
+	opts.pinned = append(opts.pinned, c.Pinned...)
+	if c.ConfirmSize != "" {
+		if err := opts.confirmSize.Set(c.ConfirmSize); err != nil {
+			return fmt.Errorf("confirm_size: %w", err)
+		}
+	}
+	if c.ConfirmTotal != "" {
+		if err := opts.confirmTotal.Set(c.ConfirmTotal); err != nil {
+			return fmt.Errorf("confirm_total: %w", err)
+		}
+	}
+	if c.MinSize != "" {
+		if err := opts.minSize.Set(c.MinSize); err != nil {
+			return fmt.Errorf("min_size: %w", err)
+		}
+	}
+	if c.OlderThan != "" {
+		if err := opts.olderThan.Set(c.OlderThan); err != nil {
+			return fmt.Errorf("older_than: %w", err)
+		}
+	}
+	if c.MaxDepth != 0 {
+		opts.maxDepth = c.MaxDepth
+	}
+	if c.KeepRecent != 0 {
+		opts.keepRecent = c.KeepRecent
+	}
+	if c.Sort != "" {
+		opts.sortBy = c.Sort
+	}
+	if c.Reverse {
+		opts.reverse = true
+	}
+	if c.Concurrency != 0 {
+		opts.workers = c.Concurrency
+	}
+	if c.SizeFormat != "" {
+		opts.sizeFormat = c.SizeFormat
+	}
+	if c.Color != "" {
+		opts.color = c.Color
+	}
+	if c.Timeout != "" {
+		d, err := time.ParseDuration(c.Timeout)
+		if err != nil {
+			return fmt.Errorf("timeout: %w", err)
+		}
+		opts.timeout = d
+	}
+	if c.Trash {
+		opts.trash = true
+	}
+	if c.RecentDays != 0 {
+		opts.recentDays = c.RecentDays
+	}
+	if c.AllowRecent {
+		opts.allowRecent = true
+	}
+	if c.ArchiveDir != "" {
+		opts.archiveDir = c.ArchiveDir
+	}
+	if c.FollowSymlinks {
+		opts.followSymlinks = true
+	}
+	if c.OneFileSystem {
+		opts.oneFileSystem = true
+	}
+	if c.SkipHidden {
+		opts.skipHidden = true
+	}
+	if c.AllowOrphans {
+		opts.allowOrphans = true
+	}
+	if c.GlobalCaches {
+		opts.globalCaches = true
+	}
+	if c.DiskUsage {
+		opts.diskUsage = true
+	}
+	if c.Format != "" {
+		if _, ok := outputFormats[c.Format]; !ok {
+			return fmt.Errorf("format: unknown output format %q (use text, json, csv or yaml)", c.Format)
+		}
+		opts.format = c.Format
+	}
+	return nil
+}
+
+// effectiveConfig describes opts in config-file form
+func effectiveConfig(opts *options) *fileConfig {
+	cfg := &fileConfig{
+		Roots:          opts.roots,
+		Detectors:      opts.detectors,
+		Categories:     opts.categories,
+		Names:          opts.names,
+		Exclude:        opts.excludes,
+		Include:        opts.includes,
+		ProtectedPaths: opts.protected,
+		Pinned:         opts.pinned,
+		MinSize:        opts.minSize.String(),
+		ConfirmSize:    opts.confirmSize.String(),
+		ConfirmTotal:   opts.confirmTotal.String(),
+		OlderThan:      opts.olderThan.String(),
+		MaxDepth:       opts.maxDepth,
+		KeepRecent:     opts.keepRecent,
+		Sort:           opts.sortBy,
+		Reverse:        opts.reverse,
+		Concurrency:    opts.workers,
+		FollowSymlinks: opts.followSymlinks,
+		OneFileSystem:  opts.oneFileSystem,
+		SkipHidden:     opts.skipHidden,
+		AllowOrphans:   opts.allowOrphans,
+		GlobalCaches:   opts.globalCaches,
+		Format:         opts.format,
+		SizeFormat:     opts.sizeFormat,
+		DiskUsage:      opts.diskUsage,
+		Color:          opts.color,
+		ArchiveDir:     opts.archiveDir,
+		Trash:          opts.trash,
+		RecentDays:     opts.recentDays,
+		AllowRecent:    opts.allowRecent,
+		Profile:        opts.profile,
+	}
+	if opts.timeout > 0 {
+		cfg.Timeout = opts.timeout.String()
+	}
+	return cfg
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"slices"
+	"testing"
+)
+
+func TestFlagsReplaceConfiguredLists(t *testing.T) {
+	cfg := &fileConfig{Exclude: []string{"**/vendor/**"}, Detectors: []string{"rust"}, Names: []string{".yarn/cache"}}
+	tests := []struct {
+		name      string
+		args      []string
+		excludes  []string
+		detectors []string
+	}{
+		{name: "config only", excludes: []string{"**/vendor/**"}, detectors: []string{"rust"}},
+		{name: "flag replaces", args: []string{"--exclude", "a"}, excludes: []string{"a"}, detectors: []string{"rust"}},
+		{name: "repeated flag", args: []string{"--exclude", "a", "--exclude", "b", "--detector", "python"}, excludes: []string{"a", "b"}, detectors: []string{"python"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts options
+			fs := flag.NewFlagSet("scan", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			opts.registerScanFlags(fs)
+			if err := cfg.apply(&opts); err != nil {
+				t.Fatal(err)
+			}
+			replaceConfiguredLists(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(opts.excludes, tt.excludes) {
+				t.Errorf("excludes = %q, want %q", opts.excludes, tt.excludes)
+			}
+			if !slices.Equal(opts.detectors, tt.detectors) {
+				t.Errorf("detectors = %q, want %q", opts.detectors, tt.detectors)
+			}
+			if !slices.Equal(opts.names, cfg.Names) {
+				t.Errorf("names = %q, want the configured %q", opts.names, cfg.Names)
+			}
+		})
+	}
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// packageCopy is one installation of a package version
+type packageCopy struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	unique int64
+}
+
+// duplicateGroup is a package version installed in several node_modules
+type duplicateGroup struct {
+	Name    string        `json:"name"`
+	Version string        `json:"version"`
+	Copies  []packageCopy `json:"copies"`
+	Wasted  int64         `json:"wasted"` // bytes held by all but one copy
+}
+
+// readPackageID returns the name and version from a package's package.json
+func readPackageID(dir string) (name, version string, ok bool) {
+	data, err := os.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return "", "", false
+	}
+	var manifest struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+	if json.Unmarshal(data, &manifest) != nil || manifest.Name == "" || manifest.Version == "" {
+		return "", "", false
+	}
+	return manifest.Name, manifest.Version, true
+}
+
+// findDuplicates groups the top-level packages of dirs by name and version
+// and returns the versions installed more than once, most wasteful first.
+// Copies that are already hard-linked to each other, as pnpm does through
+// its store, don't count as waste.
+func findDuplicates(ctx context.Context, dirs []Directory, workers int, usage bool) []duplicateGroup {
+	groups := make(map[string]*duplicateGroup)
+	var order []string
+	for _, dir := range dirs {
+		pkgs, err := packageDirs(dir.path)
+		if err != nil {
+			continue
+		}
+		for _, p := range pkgs {
+			name, version, ok := readPackageID(p.Path)
+			if !ok {
+				continue
+			}
+			id := name + "@" + version
+			if groups[id] == nil {
+				groups[id] = &duplicateGroup{Name: name, Version: version}
+				order = append(order, id)
+			}
+			groups[id].Copies = append(groups[id].Copies, packageCopy{Path: p.Path})
+		}
+	}
+
+	var (
+		wg        sync.WaitGroup
+		semaphore = make(chan struct{}, max(workers, 1))
+		dupes     []duplicateGroup
+	)
+	for _, id := range order {
+		if len(groups[id].Copies) < 2 {
+			continue
+		}
+		group := groups[id]
+		for i := range group.Copies {
+			wg.Add(1)
+			go func(c *packageCopy) {
+				defer wg.Done()
+				semaphore <- struct{}{}        // Acquire
+				defer func() { <-semaphore }() // Release
+
+				c.Size, c.unique, _ = calculateDirSize(ctx, c.Path, usage, nil)
+			}(&group.Copies[i])
+		}
+	}
+	wg.Wait()
+
+	for _, id := range order {
+		group := groups[id]
+		if len(group.Copies) < 2 {
+			continue
+		}
+		var kept int64
+		for _, c := range group.Copies {
+			group.Wasted += c.unique
+			kept = max(kept, c.unique)
+		}
+		group.Wasted -= kept
+		if group.Wasted > 0 {
+			dupes = append(dupes, *group)
+		}
+	}
+	sort.SliceStable(dupes, func(i, j int) bool { return dupes[i].Wasted > dupes[j].Wasted })
+	return dupes
+}
+
+// runDedupeReport reports package versions installed in several
+// node_modules directories and the space their extra copies take
+func runDedupeReport(ctx context.Context, opts *options, args []string) error {
+	if len(opts.detectors) == 0 && len(opts.categories) == 0 && len(opts.names) == 0 {
+		opts.detectors = []string{"node_modules"}
+	}
+	root, dirs, err := scanDirectories(ctx, opts, args, opts.progress())
+	if err != nil {
+		return err
+	}
+	var modules []Directory
+	for _, dir := range dirs {
+		if dir.kind == "node_modules" && !dir.global {
+			modules = append(modules, dir)
+		}
+	}
+	if len(modules) == 0 {
+		fmt.Fprintf(opts.progress(), "No node_modules directories found in %s\n", root)
+		return errNothingFound
+	}
+
+	fmt.Fprintf(opts.progress(), "Comparing the packages of %d node_modules directories...\n", len(modules))
+	dupes := findDuplicates(ctx, modules, opts.workers, opts.diskUsage)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if opts.format == formatJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if dupes == nil {
+			dupes = []duplicateGroup{}
+		}
+		return enc.Encode(dupes)
+	}
+
+	var wasted int64
+	for _, group := range dupes {
+		wasted += group.Wasted
+	}
+	shown := dupes
+	if opts.duplicates > 0 && len(shown) > opts.duplicates {
+		shown = shown[:opts.duplicates]
+	}
+	for _, group := range shown {
+		size := fmt.Sprintf("%10s", formatSize(group.Wasted))
+		fmt.Printf("%s  %s@%s (%d copies)\n", paintSize(os.Stdout, group.Wasted, size), group.Name, group.Version, len(group.Copies))
+	}
+	if len(shown) < len(dupes) {
+		fmt.Printf("  ... and %d more\n", len(dupes)-len(shown))
+	}
+	fmt.Printf("\nDuplicate packages waste %s across %d node_modules directories\n", paint(os.Stdout, ansiBold, formatSize(wasted)), len(modules))
+	return nil
+}
+
+// linkEntry is one line of the dedupe journal: a file replaced by a hard
+// link to an identical file
+type linkEntry struct {
+	Time   time.Time `json:"time"`
+	Path   string    `json:"path"`
+	Source string    `json:"source"`
+	Size   int64     `json:"size"`
+}
+
+// dedupeJournalPath returns where dedupe records the links it made
+func dedupeJournalPath() (string, error) {
+	return statePath("dedupe.log")
+}
+
+// fileHash returns the SHA-256 of the contents of path
+func fileHash(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// sameContent reports whether two regular files can share their data: they
+// are on the same device, not already hard links or clones of each other,
+// have equal sizes and permissions and their contents hash the same
+func sameContent(src, dst string) (info os.FileInfo, ok bool, err error) {
+	srcInfo, err := os.Lstat(src)
+	if err != nil || !srcInfo.Mode().IsRegular() {
+		return nil, false, nil
+	}
+	dstInfo, err := os.Lstat(dst)
+	if err != nil || !dstInfo.Mode().IsRegular() {
+		return nil, false, err
+	}
+	if os.SameFile(srcInfo, dstInfo) || srcInfo.Size() != dstInfo.Size() || srcInfo.Mode() != dstInfo.Mode() {
+		return nil, false, nil
+	}
+	srcDev, ok1 := deviceID(srcInfo)
+	dstDev, ok2 := deviceID(dstInfo)
+	if !ok1 || !ok2 || srcDev != dstDev {
+		return nil, false, nil
+	}
+	// Cloning them again would free nothing
+	if cloned(src, dst) {
+		return nil, false, nil
+	}
+
+	srcSum, err := fileHash(src)
+	if err != nil {
+		return nil, false, err
+	}
+	dstSum, err := fileHash(dst)
+	if err != nil {
+		return nil, false, err
+	}
+	return dstInfo, bytes.Equal(srcSum, dstSum), nil
+}
+
+// replaceFile swaps dst for a hard link to, or with useReflink a clone of,
+// src. The new file is created next to dst and renamed over it so dst is
+// never missing.
+func replaceFile(src, dst string, perm os.FileMode, useReflink bool) error {
+	tmp := dst + ".clean-modules-dedupe"
+	var err error
+	if useReflink {
+		err = reflink(src, tmp, perm)
+	} else {
+		err = os.Link(src, tmp)
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// dedupeResult tallies what deduplicating a package copy did
+type dedupeResult struct {
+	files int
+	saved int64
+	links []linkEntry
+}
+
+// dedupeCopy replaces the files of dup that are identical to the files at
+// the same place in source
+func dedupeCopy(ctx context.Context, source, dup string, dryRun, useReflink bool) (dedupeResult, error) {
+	var res dedupeResult
+	err := filepath.WalkDir(dup, func(p string, d fs.DirEntry, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dup, p)
+		if err != nil {
+			return nil
+		}
+		src := filepath.Join(source, rel)
+		info, ok, err := sameContent(src, p)
+		if err != nil {
+			slog.Info("comparing files failed", "path", p, "err", err)
+			return nil
+		}
+		if !ok {
+			return nil
+		}
+		if !dryRun {
+			err := replaceFile(src, p, info.Mode().Perm(), useReflink)
+			if useReflink && errors.Is(err, errors.ErrUnsupported) {
+				return fmt.Errorf("the filesystem of %s doesn't support reflinks, run without --reflink to use hard links", p)
+			}
+			if err != nil {
+				return fmt.Errorf("deduplicating %s: %w", p, err)
+			}
+			if !useReflink {
+				res.links = append(res.links, linkEntry{Time: time.Now(), Path: p, Source: src, Size: info.Size()})
+			}
+		}
+		res.files++
+		// Space only comes back once the last link to the old data is gone
+		if _, links, _ := hardLinks(info); links <= 1 {
+			res.saved += info.Size()
+		}
+		return nil
+	})
+	return res, err
+}
+
+// undoDedupe gives every file linked by an earlier dedupe its own copy of
+// the data again and empties the journal. With dryRun it only lists the
+// files it would separate.
+func undoDedupe(ctx context.Context, journal string, dryRun bool, progress io.Writer) error {
+	f, err := os.Open(journal)
+	if errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintln(progress, "Nothing to undo.")
+		return errNothingFound
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var restored, failed int
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var entry linkEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		srcInfo, err1 := os.Stat(entry.Source)
+		dstInfo, err2 := os.Lstat(entry.Path)
+		if err1 != nil || err2 != nil || !os.SameFile(srcInfo, dstInfo) {
+			continue // changed or removed since, nothing is shared anymore
+		}
+		if dryRun {
+			fmt.Printf("  %s\n", entry.Path)
+			restored++
+			continue
+		}
+		if err := unlinkCopy(entry.Path, dstInfo.Mode().Perm()); err != nil {
+			printError("ERROR:", err)
+			failed++
+			continue
+		}
+		restored++
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading %s: %w", journal, err)
+	}
+	if dryRun {
+		fmt.Printf("\nWould give %d files their own copy again.\nNothing was changed (--dry-run).\n", restored)
+		return nil
+	}
+	fmt.Printf("Gave %d files their own copy again\n", restored)
+	if failed > 0 {
+		return &codeError{
+			code: exitPartialFailure,
+			err:  fmt.Errorf("%d of %d files could not be separated, run --undo again to retry", failed, restored+failed),
+		}
+	}
+	return os.Remove(journal)
+}
+
+// unlinkCopy replaces the hard link at path with an independent copy
+func unlinkCopy(path string, perm os.FileMode) error {
+	tmp := path + ".clean-modules-dedupe"
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("copying %s: %w", path, err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// runDedupe replaces identical files in duplicate package copies with hard
+// links (or reflinks) to a single copy. Every hard link is journaled so
+// --undo can separate the files again.
+func runDedupe(ctx context.Context, opts *options, args []string) error {
+	if err := guardRoot(opts); err != nil {
+		return err
+	}
+	unlock, err := lockRun(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	journal, err := dedupeJournalPath()
+	if err != nil {
+		return err
+	}
+	progress := opts.progress()
+	if opts.undo {
+		return undoDedupe(ctx, journal, opts.dryRun, progress)
+	}
+
+	if len(opts.detectors) == 0 && len(opts.categories) == 0 && len(opts.names) == 0 {
+		opts.detectors = []string{"node_modules"}
+	}
+	root, dirs, err := scanDirectories(ctx, opts, args, progress)
+	if err != nil {
+		return err
+	}
+	var modules []Directory
+	for _, dir := range dirs {
+		if dir.kind == "node_modules" && !dir.global {
+			modules = append(modules, dir)
+		}
+	}
+	fmt.Fprintf(progress, "Comparing the packages of %d node_modules directories...\n", len(modules))
+	dupes := findDuplicates(ctx, modules, opts.workers, opts.diskUsage)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(dupes) == 0 {
+		fmt.Fprintf(progress, "No duplicate packages found in %s\n", root)
+		return errNothingFound
+	}
+
+	var wasted int64
+	for _, group := range dupes {
+		wasted += group.Wasted
+	}
+	if !opts.dryRun && !opts.yes {
+		method := "hard links"
+		if opts.reflink {
+			method = "copy-on-write clones"
+		}
+		confirm, err := askConfirm(fmt.Sprintf("Replace identical files in %d duplicate packages (up to %s) with %s?", len(dupes), formatSize(wasted), method))
+		if err != nil {
+			return fmt.Errorf("during confirmation: %w", err)
+		}
+		if !confirm {
+			fmt.Fprintln(progress, "Operation cancelled.")
+			return errAborted
+		}
+	}
+
+	var record *json.Encoder
+	if !opts.dryRun && !opts.reflink {
+		if err := os.MkdirAll(filepath.Dir(journal), 0o755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(journal, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return fmt.Errorf("opening dedupe journal: %w", err)
+		}
+		defer f.Close()
+		record = json.NewEncoder(f)
+	}
+
+	var total dedupeResult
+	for _, group := range dupes {
+		source := group.Copies[0].Path
+		for _, c := range group.Copies[1:] {
+			res, err := dedupeCopy(ctx, source, c.Path, opts.dryRun, opts.reflink)
+			for _, entry := range res.links {
+				if err := record.Encode(entry); err != nil {
+					slog.Warn("writing dedupe journal failed", "err", err)
+				}
+			}
+			total.files += res.files
+			total.saved += res.saved
+			if err != nil {
+				return err
+			}
+		}
+		slog.Debug("deduplicated package", "name", group.Name, "version", group.Version, "copies", len(group.Copies))
+	}
+
+	if opts.dryRun {
+		fmt.Printf("Would share %d identical files and free %s. Nothing was changed (--dry-run).\n", total.files, paint(os.Stdout, ansiBold, formatSize(total.saved)))
+		return nil
+	}
+	fmt.Printf("Shared %d identical files and freed %s\n", total.files, paint(os.Stdout, ansiBold, formatSize(total.saved)))
+	if !opts.reflink {
+		fmt.Fprintln(progress, "Run 'clean-modules dedupe --undo' to give each project its own copies again.")
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDedupeCopySkipsSharedFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		reflink bool
+	}{
+		{name: "hard links"},
+		{name: "reflinks", reflink: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			source, dup := filepath.Join(root, "a", "pkg"), filepath.Join(root, "b", "pkg")
+			// Large enough to live in extents of its own rather than inline
+			data := bytes.Repeat([]byte("module.exports = 1\n"), 4096)
+			for _, dir := range []string{source, dup} {
+				mkdirs(t, dir, "lib")
+				if err := os.WriteFile(filepath.Join(dir, "lib", "index.js"), data, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.reflink {
+				probe := filepath.Join(root, "probe")
+				if err := reflink(filepath.Join(source, "lib", "index.js"), probe, 0o644); err != nil {
+					t.Skipf("the filesystem can't clone files: %v", err)
+				}
+			}
+
+			first, err := dedupeCopy(context.Background(), source, dup, false, tt.reflink)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if first.files != 1 || first.saved != int64(len(data)) {
+				t.Fatalf("first run shared %d files and saved %d, want 1 and %d", first.files, first.saved, len(data))
+			}
+			again, err := dedupeCopy(context.Background(), source, dup, false, tt.reflink)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again.files != 0 || again.saved != 0 || len(again.links) != 0 {
+				t.Errorf("second run shared %d files and saved %d, want nothing", again.files, again.saved)
+			}
+		})
+	}
+}
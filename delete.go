@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AlecAivazis/survey/v2"
+)
+
+// deleteDirectory deletes a directory, or with trash moves it to the trash,
+// and reports how long it took and where a trashed directory went
+func deleteDirectory(dir Directory, trash bool) (time.Duration, string, error) {
+	if err := revalidate(dir); err != nil {
+		return 0, "", err
+	}
+	start := time.Now()
+	var (
+		trashed string
+		err     error
+	)
+	if trash {
+		trashed, err = moveToTrash(dir.path)
+	} else {
+		err = stageAndRemove(dir.path)
+	}
+	duration := time.Since(start)
+
+	if err != nil && trash {
+		return duration, "", fmt.Errorf("failed to move %s to the trash: %w", dir.path, err)
+	}
+	if err != nil {
+		return duration, "", fmt.Errorf("failed to delete %s: %w", dir.path, err)
+	}
+	return duration, trashed, nil
+}
+
+// stagedMarker is part of the name a directory is renamed to before it is
+// removed, so leftovers of an interrupted deletion can be recognized
+const stagedMarker = ".clean-modules-deleting-"
+
+// isStaged reports whether name is that of a directory renamed for removal
+func isStaged(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.Contains(name, stagedMarker)
+}
+
+// stageAndRemove renames path to a hidden sibling and then removes that, so
+// path disappears at once and an interrupted removal never leaves a partial
+// tree behind for package managers to trip over. When the rename fails,
+// e.g. because a file is in use on Windows, path is removed in place.
+func stageAndRemove(path string) error {
+	staged := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s%s%d", filepath.Base(path), stagedMarker, time.Now().UnixNano()))
+	if err := os.Rename(path, staged); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		slog.Info("renaming before removal failed, removing in place", "path", path, "err", err)
+		return removeTree(path)
+	}
+	slog.Debug("renamed for removal", "path", path, "staged", staged)
+	if err := removeTree(staged); err != nil {
+		return fmt.Errorf("%w (the rest is left in %s)", err, staged)
+	}
+	return nil
+}
+
+// removeTree deletes path and everything below it. When path itself is a
+// symlink, or a junction on Windows, only the link is removed; links inside
+// the tree are likewise removed without touching their targets.
+func removeTree(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != fs.ModeDir {
+		slog.Info("removing link instead of its target", "path", path)
+		return os.Remove(path)
+	}
+	return removeAll(path)
+}
+
+// revalidate makes sure dir is still what was found and selected, as a long
+// selection leaves time for it to be deleted, moved or replaced, possibly by
+// a symlink into something that must not be deleted
+func revalidate(dir Directory) error {
+	info, err := os.Lstat(dir.path)
+	switch {
+	case err != nil:
+		return fmt.Errorf("not deleting %s: %w", dir.path, err)
+	case info.Mode()&os.ModeSymlink != 0:
+		return fmt.Errorf("not deleting %s: it was replaced by a symlink since the scan", dir.path)
+	case !info.IsDir():
+		return fmt.Errorf("not deleting %s: it is no longer a directory", dir.path)
+	case dir.stat == nil && dir.identity == nil:
+		return fmt.Errorf("not deleting %s: nothing was recorded to tell whether it was replaced since the scan", dir.path)
+	case dir.stat != nil && !os.SameFile(dir.stat, info),
+		dir.stat == nil && !dir.identity.matches(info):
+		// Also catches a parent replaced by a symlink to elsewhere
+		return fmt.Errorf("not deleting %s: it was replaced by another directory since the scan", dir.path)
+	}
+	return nil
+}
+
+// deleteResult is the outcome of deleting one directory
+type deleteResult struct {
+	dir      Directory
+	duration time.Duration
+	trashed  bool   // moved to the trash rather than deleted
+	trash    string // where it went in the trash, if known
+	err      error
+}
+
+// deleteSummary tallies the outcome of a deletion run
+type deleteSummary struct {
+	deleted  int
+	failed   int
+	freed    int64
+	duration time.Duration
+	results  []deleteResult
+	skipped  []Directory // not started because the run was interrupted
+}
+
+var (
+	ttyOnce sync.Once
+	tty     *os.File
+)
+
+// askOptions makes prompts read from the controlling terminal when stdin is
+// not one, e.g. when paths are piped in with --stdin
+func askOptions() []survey.AskOpt {
+	ttyOnce.Do(func() {
+		if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			return
+		}
+		name := "/dev/tty"
+		if runtime.GOOS == "windows" {
+			name = "CONIN$"
+		}
+		tty, _ = os.Open(name)
+	})
+	if tty == nil {
+		return nil
+	}
+	return []survey.AskOpt{survey.WithStdio(tty, os.Stdout, os.Stderr)}
+}
+
+// selectDirectories asks the user which directories to delete. With
+// selectAll every entry starts out checked. With units the directories of a
+// workspace are offered as one entry that selects all of them.
+func selectDirectories(dirs []Directory, selectAll, units bool) ([]Directory, error) {
+	groups := make([][]Directory, 0, len(dirs))
+	if units {
+		groups = groupWorkspaces(dirs)
+	} else {
+		for _, dir := range dirs {
+			groups = append(groups, []Directory{dir})
+		}
+	}
+
+	// Create options with sizes
+	var options []string
+	for _, group := range groups {
+		if len(group) > 1 {
+			options = append(options, fmt.Sprintf("%s (workspace, %d directories, %s)", group[0].workspace, len(group), totalSizeString(group)))
+			continue
+		}
+		dir := group[0]
+		option := fmt.Sprintf("%s (%s)", dir.path, dir.sizeString())
+		if mark := dir.restorability(); mark != "" {
+			option += " " + mark
+		}
+		options = append(options, option)
+	}
+
+	var selectedIndices []int
+	prompt := &survey.MultiSelect{
+		Message:  fmt.Sprintf("Found %d directories. Select directories to DELETE:", len(dirs)),
+		Options:  options,
+		PageSize: 50,
+	}
+	if selectAll {
+		all := make([]int, len(groups))
+		for i := range all {
+			all[i] = i
+		}
+		prompt.Default = all
+	}
+
+	if err := survey.AskOne(prompt, &selectedIndices, askOptions()...); err != nil {
+		return nil, err
+	}
+
+	selected := make([]Directory, 0, len(selectedIndices))
+	for _, idx := range selectedIndices {
+		selected = append(selected, groups[idx]...)
+	}
+	return selected, nil
+}
+
+// printDryRun lists what would be deleted without touching the filesystem
+func printDryRun(w io.Writer, dirs []Directory, totalSize string) {
+	fmt.Fprintf(w, "\nDry run: the following %d directories would be deleted:\n", len(dirs))
+	for _, dir := range dirs {
+		fmt.Fprintf(w, "  %s (%s)\n", dir.path, paintSize(w, dir.size, dir.sizeString()))
+		if hint := dir.restoreHint(); hint != "" {
+			fmt.Fprintf(w, "      restore with: %s\n", hint)
+		}
+	}
+	fmt.Fprintf(w, "\nTotal space that would be reclaimed: %s\n", paint(w, ansiBold, totalSize))
+	if unique := sumUnique(dirs); totalSize != "unknown" && unique < sumSizes(dirs) {
+		fmt.Fprintf(w, "Only %s of it is unique to these directories, the rest is hard-linked elsewhere.\n", formatSize(unique))
+	}
+	fmt.Fprintln(w, "Nothing was deleted (--dry-run).")
+}
+
+// Sizes above which deleting needs a project name typed out instead of a
+// yes/no answer
+const (
+	defaultConfirmSize  = 5 << 30  // a single directory
+	defaultConfirmTotal = 50 << 30 // all selected directories together
+)
+
+// confirmDeletion asks for a final confirmation before deleting. When a
+// directory exceeds --confirm-size or all of them exceed --confirm-total, the
+// user has to type the project name of the largest one instead.
+func confirmDeletion(dirs []Directory, totalSize string, opts *options) (bool, error) {
+	largest := dirs[0]
+	for _, dir := range dirs {
+		if dir.size > largest.size {
+			largest = dir
+		}
+	}
+	var reason string
+	switch total := sumSizes(dirs); {
+	case opts.confirmSize > 0 && largest.size > int64(opts.confirmSize):
+		reason = fmt.Sprintf("%s alone is %s", largest.path, largest.sizeString())
+	case opts.confirmTotal > 0 && total > int64(opts.confirmTotal):
+		reason = fmt.Sprintf("This deletes %s in %d directories", totalSize, len(dirs))
+	default:
+		return askConfirm(fmt.Sprintf("Are you sure you want to DELETE %d directories (total size: %s)? This cannot be undone!",
+			len(dirs),
+			totalSize))
+	}
+
+	name := confirmName(largest)
+	var answer string
+	prompt := &survey.Input{Message: fmt.Sprintf("%s. Type %q to DELETE %d directories (total size: %s):", reason, name, len(dirs), totalSize)}
+	if err := survey.AskOne(prompt, &answer, askOptions()...); err != nil {
+		return false, err
+	}
+	if strings.TrimSpace(answer) != name {
+		printWarning("%q doesn't match %q", answer, name)
+		return false, nil
+	}
+	return true, nil
+}
+
+// confirmName returns what has to be typed to delete dir: its project's
+// name, or for a global cache, which belongs to no project, its own
+func confirmName(dir Directory) string {
+	if dir.project == "" {
+		return filepath.Base(dir.path)
+	}
+	return filepath.Base(dir.project)
+}
+
+// askConfirm asks a yes/no question that defaults to no
+func askConfirm(message string) (bool, error) {
+	var confirm bool
+	err := survey.AskOne(&survey.Confirm{Message: message}, &confirm, askOptions()...)
+	return confirm, err
+}
+
+// deleteDirectories deletes dirs, or with trash moves them to the trash,
+// concurrently and at most workers at a time. Each deletion is reported to
+// progress, failures to stderr and every outcome to the audit log. Once ctx
+// is done no further deletions start, those in progress still finish.
+func deleteDirectories(ctx context.Context, dirs []Directory, workers int, trash bool, progress io.Writer, audit *auditLog) deleteSummary {
+	var (
+		summary   deleteSummary
+		mutex     sync.Mutex
+		deleteWg  sync.WaitGroup
+		semaphore = make(chan struct{}, max(workers, 1))
+		start     = time.Now()
+	)
+
+	for _, dir := range dirs {
+		deleteWg.Add(1)
+		go func(dir Directory) {
+			defer deleteWg.Done()
+			semaphore <- struct{}{}        // Acquire
+			defer func() { <-semaphore }() // Release
+			if ctx.Err() != nil {
+				mutex.Lock()
+				summary.skipped = append(summary.skipped, dir)
+				mutex.Unlock()
+				return
+			}
+
+			duration, trashed, err := deleteDirectory(dir, trash)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			result := deleteResult{dir: dir, duration: duration, trashed: trash, trash: trashed, err: err}
+			summary.results = append(summary.results, result)
+			if aerr := audit.record(result); aerr != nil {
+				slog.Warn("writing audit log failed", "err", aerr)
+			}
+			if err != nil {
+				summary.failed++
+				slog.Info("deletion failed", "path", dir.path, "duration", duration, "err", err)
+				printError("ERROR:", err)
+				return
+			}
+			slog.Debug("deleted directory", "path", dir.path, "size", dir.size, "duration", duration)
+			summary.deleted++
+			summary.freed += dir.unique
+			verb := "Deleted"
+			if trash {
+				verb = "Moved to trash"
+			}
+			fmt.Fprintf(progress, "%s [%s] (%s) in %s ✅\n",
+				verb,
+				dir.path,
+				paintSize(progress, dir.size, dir.sizeString()),
+				duration.Round(time.Millisecond))
+		}(dir)
+	}
+
+	deleteWg.Wait()
+	summary.duration = time.Since(start)
+	slog.Info("deletion finished", "deleted", summary.deleted, "failed", summary.failed, "skipped", len(summary.skipped), "duration", summary.duration.Round(time.Millisecond))
+	return summary
+}
+
+// interrupted lists the directories an interrupted deletion never got to
+// and returns the error to exit with, or nil if it wasn't interrupted
+func (s deleteSummary) interrupted() error {
+	if len(s.skipped) == 0 {
+		return nil
+	}
+	var paths []string
+	for _, dir := range s.skipped {
+		paths = append(paths, "  "+dir.path)
+	}
+	printWarning("interrupted after deleting %d directories (%s freed), these %d were left in place:\n%s",
+		s.deleted, formatSize(s.freed), len(s.skipped), strings.Join(paths, "\n"))
+	return errAborted
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestRemoveTreeKeepsLinkTargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		windows bool // only meaningful on Windows
+		// setup creates the tree to remove below dir, pointing links at
+		// outside, and returns its path
+		setup func(t *testing.T, dir, outside string) string
+	}{
+		{
+			name: "symlinked candidate",
+			setup: func(t *testing.T, dir, outside string) string {
+				path := filepath.Join(dir, "node_modules")
+				symlink(t, outside, path)
+				return path
+			},
+		},
+		{
+			name: "link inside the tree",
+			setup: func(t *testing.T, dir, outside string) string {
+				path := filepath.Join(dir, "node_modules")
+				if err := os.MkdirAll(filepath.Join(path, "pkg"), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				symlink(t, outside, filepath.Join(path, "pkg", "linked"))
+				return path
+			},
+		},
+		{
+			name:    "junction",
+			windows: true,
+			setup: func(t *testing.T, dir, outside string) string {
+				path := filepath.Join(dir, "node_modules")
+				if err := os.MkdirAll(path, 0o755); err != nil {
+					t.Fatal(err)
+				}
+				junction := filepath.Join(path, "linked")
+				if out, err := exec.Command("cmd", "/c", "mklink", "/J", junction, outside).CombinedOutput(); err != nil {
+					t.Skipf("creating a junction: %v: %s", err, out)
+				}
+				return path
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.windows && runtime.GOOS != "windows" {
+				t.Skip("junctions only exist on Windows")
+			}
+			dir, outside := t.TempDir(), t.TempDir()
+			keep := filepath.Join(outside, "keep.txt")
+			if err := os.WriteFile(keep, []byte("keep"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			path := tt.setup(t, dir, outside)
+
+			if err := removeTree(path); err != nil {
+				t.Fatalf("removeTree(%s) = %v", path, err)
+			}
+			if _, err := os.Lstat(path); !os.IsNotExist(err) {
+				t.Errorf("%s still exists (err = %v)", path, err)
+			}
+			if _, err := os.Stat(keep); err != nil {
+				t.Errorf("link target was touched: %v", err)
+			}
+		})
+	}
+}
+
+// symlink creates link pointing at target, skipping the test where that
+// takes privileges the test doesn't have
+func symlink(t *testing.T, target, link string) {
+	t.Helper()
+	if err := os.Symlink(target, link); err != nil {
+		if runtime.GOOS == "windows" {
+			t.Skipf("creating a symlink: %v", err)
+		}
+		t.Fatal(err)
+	}
+}
+
+func TestConfirmName(t *testing.T) {
+	tests := []struct {
+		name string
+		dir  Directory
+		want string
+	}{
+		{"project", Directory{path: filepath.Join("work", "app", "node_modules"), project: filepath.Join("work", "app")}, "app"},
+		{"nested target", Directory{path: filepath.Join("work", "app", ".yarn", "cache"), project: filepath.Join("work", "app")}, "app"},
+		{"global cache", Directory{path: filepath.Join("home", ".npm", "_cacache"), global: true}, "_cacache"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := confirmName(tt.dir); got != tt.want {
+				t.Errorf("confirmName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRevalidate(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup creates the directory at path, records it the way the scan
+		// or the plan does and then changes it
+		setup   func(t *testing.T, path string) Directory
+		wantErr bool
+	}{
+		{
+			name: "unchanged",
+			setup: func(t *testing.T, path string) Directory {
+				return Directory{path: path, stat: lstat(t, path)}
+			},
+		},
+		{
+			name: "unchanged since the plan",
+			setup: func(t *testing.T, path string) Directory {
+				id := identityOf(lstat(t, path))
+				return Directory{path: path, identity: &id}
+			},
+		},
+		{
+			name: "deleted",
+			setup: func(t *testing.T, path string) Directory {
+				dir := Directory{path: path, stat: lstat(t, path)}
+				if err := os.Remove(path); err != nil {
+					t.Fatal(err)
+				}
+				return dir
+			},
+			wantErr: true,
+		},
+		{
+			name: "replaced by a symlink",
+			setup: func(t *testing.T, path string) Directory {
+				dir := Directory{path: path, stat: lstat(t, path)}
+				if err := os.Remove(path); err != nil {
+					t.Fatal(err)
+				}
+				symlink(t, t.TempDir(), path)
+				return dir
+			},
+			wantErr: true,
+		},
+		{
+			name: "replaced by a file",
+			setup: func(t *testing.T, path string) Directory {
+				dir := Directory{path: path, stat: lstat(t, path)}
+				if err := os.Remove(path); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return dir
+			},
+			wantErr: true,
+		},
+		{
+			name: "replaced by another directory",
+			setup: func(t *testing.T, path string) Directory {
+				return Directory{path: path, stat: lstat(t, replaceDir(t, path))}
+			},
+			wantErr: true,
+		},
+		{
+			name: "replaced since the plan",
+			setup: func(t *testing.T, path string) Directory {
+				id := identityOf(lstat(t, replaceDir(t, path)))
+				if id.Inode == 0 {
+					t.Skip("no inode numbers to tell directories apart")
+				}
+				return Directory{path: path, identity: &id}
+			},
+			wantErr: true,
+		},
+		{
+			name: "nothing recorded",
+			setup: func(t *testing.T, path string) Directory {
+				return Directory{path: path}
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "node_modules")
+			if err := os.Mkdir(path, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			dir := tt.setup(t, path)
+			if err := revalidate(dir); (err != nil) != tt.wantErr {
+				t.Errorf("revalidate() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// lstat returns the file info of path
+func lstat(t *testing.T, path string) os.FileInfo {
+	t.Helper()
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+// replaceDir puts a new directory in the place of path and returns where
+// the original went, which still exists so it can't lend its inode
+func replaceDir(t *testing.T, path string) string {
+	t.Helper()
+	moved := path + ".old"
+	if err := os.Rename(path, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(path, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return moved
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// detector recognizes one kind of regenerable directory. A directory matches
+// when its name matches one of dirs and, if markers are given, the project
+// holding it contains at least one of them.
+type detector struct {
+	name     string   // unique name used with --detector
+	category string   // group of related detectors, e.g. dependencies
+	risk     string   // how much could be lost by deleting a match
+	dirs     []string // directory names or globs, possibly nested like .yarn/cache
+	files    []string // file names or globs, for caches kept in a single file
+	markers  []string // project files or globs proving what dirs belong to
+	unless   []string // project files ruling a match out
+	contains []string // entries a matched directory must contain
+	global   []string // machine-wide cache directories, offered with --global-caches
+	restore  string   // command that regenerates the directory
+	notes    []detectorNote
+}
+
+// Risk levels of detectors
+const (
+	riskLow    = "low"    // fully regenerable, e.g. caches and build output
+	riskMedium = "medium" // regenerable, but may hold local changes or settings
+	riskHigh   = "high"   // hard to restore, check before deleting
+)
+
+// detectorNote is shown next to a match whose project contains Marker
+type detectorNote struct {
+	Marker string `yaml:"marker"`
+	Text   string `yaml:"text"`
+}
+
+// knownDetectorsYAML defines the ecosystems supported out of the box, in
+// the same format as custom_detectors in the config file
+//
+//go:embed detectors.yaml
+var knownDetectorsYAML []byte
+
+// detectorSpec is the config form of a detector
+type detectorSpec struct {
+	Name     string         `yaml:"name"`
+	Category string         `yaml:"category,omitempty"`
+	Risk     string         `yaml:"risk,omitempty"`
+	Dirs     []string       `yaml:"dirs,omitempty"`
+	Files    []string       `yaml:"files,omitempty"`
+	Markers  []string       `yaml:"markers,omitempty"`
+	Unless   []string       `yaml:"unless,omitempty"`
+	Contains []string       `yaml:"contains,omitempty"`
+	Global   []string       `yaml:"global,omitempty"`
+	Restore  string         `yaml:"restore,omitempty"`
+	Notes    []detectorNote `yaml:"notes,omitempty"`
+}
+
+// knownDetectors are the built-in detectors followed by custom ones. A custom
+// detector with a built-in's name replaces it in place.
+var knownDetectors = mustParseDetectors(knownDetectorsYAML)
+
+// mustParseDetectors parses the built-in definitions, which are known to be
+// valid
+func mustParseDetectors(data []byte) []*detector {
+	var specs []detectorSpec
+	if err := yaml.Unmarshal(data, &specs); err != nil {
+		panic(fmt.Sprintf("parsing built-in detectors: %v", err))
+	}
+	var list []*detector
+	for _, spec := range specs {
+		d, err := newDetector(spec)
+		if err != nil {
+			panic(fmt.Sprintf("built-in detector: %v", err))
+		}
+		list = append(list, d)
+	}
+	return list
+}
+
+// newDetector validates spec and converts it into a detector
+func newDetector(spec detectorSpec) (*detector, error) {
+	if spec.Name == "" {
+		return nil, fmt.Errorf("detector without a name")
+	}
+	if len(spec.Dirs) == 0 && len(spec.Files) == 0 && len(spec.Global) == 0 {
+		return nil, fmt.Errorf("detector %s: needs dirs, files or global paths", spec.Name)
+	}
+	switch spec.Risk {
+	case "":
+		spec.Risk = riskMedium
+	case riskLow, riskMedium, riskHigh:
+	default:
+		return nil, fmt.Errorf("detector %s: unknown risk %q (use low, medium or high)", spec.Name, spec.Risk)
+	}
+	if spec.Category == "" {
+		spec.Category = "custom"
+	}
+	for _, pattern := range append(append([]string(nil), spec.Markers...), spec.Unless...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("detector %s: invalid marker %q: %w", spec.Name, pattern, err)
+		}
+	}
+
+	d := &detector{
+		name:     spec.Name,
+		category: spec.Category,
+		risk:     spec.Risk,
+		dirs:     spec.Dirs,
+		files:    spec.Files,
+		markers:  spec.Markers,
+		unless:   spec.Unless,
+		contains: spec.Contains,
+		global:   spec.Global,
+		restore:  spec.Restore,
+		notes:    spec.Notes,
+	}
+	// Catch bad directory patterns now rather than on first use
+	if _, err := compileTargets(nil, []*detector{d}); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// registerDetectors adds custom detectors to knownDetectors
+func registerDetectors(specs []detectorSpec) error {
+	for _, spec := range specs {
+		d, err := newDetector(spec)
+		if err != nil {
+			return err
+		}
+		replaced := false
+		for i, known := range knownDetectors {
+			if known.name == d.name {
+				knownDetectors[i], replaced = d, true
+				break
+			}
+		}
+		if !replaced {
+			knownDetectors = append(knownDetectors, d)
+		}
+	}
+	return nil
+}
+
+// defaultDetectors are used when neither --name nor --detector is given
+var defaultDetectors = []string{"node_modules", "yarn-pnp"}
+
+// findDetector returns the detector called name
+func findDetector(name string) (*detector, error) {
+	for _, d := range knownDetectors {
+		if d.name == name {
+			return d, nil
+		}
+	}
+	var names []string
+	for _, d := range knownDetectors {
+		names = append(names, d.name)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown detector %q (available: %s)", name, strings.Join(names, ", "))
+}
+
+// notesFor returns the notes that apply to a match in project
+func (d *detector) notesFor(project string) []string {
+	var notes []string
+	for _, n := range d.notes {
+		if exists(project, n.Marker) {
+			notes = append(notes, n.Text)
+		}
+	}
+	return notes
+}
+
+// selectDetectors resolves --detector names and every detector of the
+// --category names, falling back to defaultDetectors when nothing, not even
+// a plain --name pattern, is given
+func selectDetectors(names, detectors, categories []string) ([]*detector, error) {
+	if len(names) == 0 && len(detectors) == 0 && len(categories) == 0 {
+		detectors = defaultDetectors
+	}
+
+	seen := make(map[*detector]bool)
+	add := func(d *detector) { seen[d] = true }
+	for _, name := range detectors {
+		d, err := findDetector(name)
+		if err != nil {
+			return nil, err
+		}
+		add(d)
+	}
+	for _, category := range categories {
+		found := false
+		for _, d := range knownDetectors {
+			if d.category == category {
+				add(d)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown category %q (available: %s)", category, strings.Join(detectorCategories(), ", "))
+		}
+	}
+
+	// Keep the definition order, so overlapping detectors like android and
+	// gradle resolve the same way however they were selected
+	var selected []*detector
+	for _, d := range knownDetectors {
+		if seen[d] {
+			selected = append(selected, d)
+		}
+	}
+	return selected, nil
+}
+
+// detectorCategories lists the categories of the known detectors
+func detectorCategories() []string {
+	var categories []string
+	seen := make(map[string]bool)
+	for _, d := range knownDetectors {
+		if !seen[d.category] {
+			seen[d.category] = true
+			categories = append(categories, d.category)
+		}
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+// exists reports whether pattern, a file name or glob, matches below dir
+func exists(dir, pattern string) bool {
+	matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+	return len(matches) > 0
+}
+
+// accepts reports whether the candidate path in project really belongs to
+// d: project must contain one of the markers, if any, and none of the
+// unless files, and path must contain every required entry
+func (d *detector) accepts(project, path string) bool {
+	for _, marker := range d.unless {
+		if exists(project, marker) {
+			return false
+		}
+	}
+	for _, entry := range d.contains {
+		if !exists(path, entry) {
+			return false
+		}
+	}
+	if len(d.markers) == 0 {
+		return true
+	}
+	for _, marker := range d.markers {
+		if exists(project, marker) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// deviceID is unknown here, so --one-file-system has no effect
+func deviceID(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// diskUsage falls back to the apparent size where block counts aren't
+// available
+func diskUsage(info os.FileInfo) int64 {
+	return info.Size()
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/AlecAivazis/survey/v2"
+)
+
+// dockerItem is a reclaimable Docker object
+type dockerItem struct {
+	Kind string `json:"kind"` // image, container or volume
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// dockerClient talks to the Docker Engine API over its unix socket
+type dockerClient struct {
+	http *http.Client
+}
+
+// newDockerClient connects to $DOCKER_HOST, or the default socket when unset
+func newDockerClient() (*dockerClient, error) {
+	socket := "/var/run/docker.sock"
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		path, ok := strings.CutPrefix(host, "unix://")
+		if !ok {
+			return nil, fmt.Errorf("unsupported DOCKER_HOST %q (only unix:// sockets are supported)", host)
+		}
+		socket = path
+	}
+	if _, err := os.Stat(socket); err != nil {
+		return nil, fmt.Errorf("docker doesn't seem to be running: %w", err)
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}
+	return &dockerClient{http: &http.Client{Transport: transport}}, nil
+}
+
+// do sends a request to the API and decodes a JSON response into v, if given
+func (c *dockerClient) do(ctx context.Context, method, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, method, "http://docker"+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct{ Message string }
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("docker %s %s: %s", method, path, apiErr.Message)
+	}
+	if v == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// filterQuery encodes Docker API filters
+func filterQuery(filters map[string][]string) string {
+	data, _ := json.Marshal(filters)
+	return url.QueryEscape(string(data))
+}
+
+// reclaimable lists dangling images, stopped containers and unused volumes
+func (c *dockerClient) reclaimable(ctx context.Context) ([]dockerItem, error) {
+	var items []dockerItem
+
+	var images []struct {
+		ID   string `json:"Id"`
+		Size int64
+	}
+	if err := c.do(ctx, http.MethodGet, "/images/json?filters="+filterQuery(map[string][]string{"dangling": {"true"}}), &images); err != nil {
+		return nil, err
+	}
+	for _, img := range images {
+		id := strings.TrimPrefix(img.ID, "sha256:")
+		items = append(items, dockerItem{Kind: "image", ID: img.ID, Name: id[:min(12, len(id))], Size: img.Size})
+	}
+
+	var containers []struct {
+		ID     string `json:"Id"`
+		Names  []string
+		SizeRw int64
+	}
+	status := filterQuery(map[string][]string{"status": {"created", "exited", "dead"}})
+	if err := c.do(ctx, http.MethodGet, "/containers/json?all=1&size=1&filters="+status, &containers); err != nil {
+		return nil, err
+	}
+	for _, ctr := range containers {
+		name := ctr.ID[:min(12, len(ctr.ID))]
+		if len(ctr.Names) > 0 {
+			name = strings.TrimPrefix(ctr.Names[0], "/")
+		}
+		items = append(items, dockerItem{Kind: "container", ID: ctr.ID, Name: name, Size: ctr.SizeRw})
+	}
+
+	// Only the disk usage endpoint reports volume sizes and reference counts
+	var df struct {
+		Volumes []struct {
+			Name      string
+			UsageData struct {
+				Size     int64
+				RefCount int64
+			}
+		}
+	}
+	if err := c.do(ctx, http.MethodGet, "/system/df", &df); err != nil {
+		return nil, err
+	}
+	for _, vol := range df.Volumes {
+		if vol.UsageData.RefCount == 0 {
+			items = append(items, dockerItem{Kind: "volume", ID: vol.Name, Name: vol.Name, Size: max(vol.UsageData.Size, 0)})
+		}
+	}
+	return items, nil
+}
+
+// remove deletes a reclaimable item
+func (c *dockerClient) remove(ctx context.Context, item dockerItem) error {
+	switch item.Kind {
+	case "image":
+		return c.do(ctx, http.MethodDelete, "/images/"+url.PathEscape(item.ID), nil)
+	case "container":
+		return c.do(ctx, http.MethodDelete, "/containers/"+url.PathEscape(item.ID), nil)
+	case "volume":
+		return c.do(ctx, http.MethodDelete, "/volumes/"+url.PathEscape(item.ID), nil)
+	}
+	return fmt.Errorf("unknown docker object kind %q", item.Kind)
+}
+
+// runDocker reports reclaimable Docker disk usage and removes it with --prune
+func runDocker(ctx context.Context, opts *options, args []string) error {
+	client, err := newDockerClient()
+	if err != nil {
+		return err
+	}
+	items, err := client.reclaimable(ctx)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, item := range items {
+		total += item.Size
+	}
+
+	if opts.format == formatJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(items); err != nil {
+			return err
+		}
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, item := range items {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", paintSize(os.Stdout, item.Size, formatSize(item.Size)), item.Kind, item.Name)
+		}
+		tw.Flush()
+		fmt.Printf("\nFound %d reclaimable Docker objects (total size: %s)\n", len(items), paint(os.Stdout, ansiBold, formatSize(total)))
+	}
+	if len(items) == 0 {
+		return errNothingFound
+	}
+	if !opts.prune || opts.dryRun {
+		return nil
+	}
+
+	if !opts.yes {
+		var confirm bool
+		prompt := &survey.Confirm{
+			Message: fmt.Sprintf("Remove %d Docker objects (total size: %s)? This cannot be undone!", len(items), formatSize(total)),
+		}
+		if err := survey.AskOne(prompt, &confirm, askOptions()...); err != nil {
+			return fmt.Errorf("during confirmation: %w", err)
+		}
+		if !confirm {
+			fmt.Fprintln(opts.progress(), "Operation cancelled.")
+			return errAborted
+		}
+	}
+
+	// Containers first, so the images and volumes they used become removable
+	var failed []error
+	for _, kind := range []string{"container", "image", "volume"} {
+		for _, item := range items {
+			if item.Kind != kind {
+				continue
+			}
+			if err := client.remove(ctx, item); err != nil {
+				printError("ERROR:", err)
+				failed = append(failed, err)
+				continue
+			}
+			fmt.Fprintf(opts.progress(), "Removed %s %s (%s) ✅\n", item.Kind, item.Name, formatSize(item.Size))
+		}
+	}
+	if len(failed) > 0 {
+		return &codeError{
+			code: exitPartialFailure,
+			err:  fmt.Errorf("%d of %d Docker objects could not be removed: %w", len(failed), len(items), errors.Join(failed...)),
+		}
+	}
+	return nil
+}
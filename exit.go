@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/AlecAivazis/survey/v2/terminal"
+)
+
+// Process exit codes
+const (
+	exitOK             = 0
+	exitError          = 1 // invalid usage or a scan error
+	exitPartialFailure = 2 // some deletions failed
+	exitAborted        = 3 // the user cancelled
+	exitNothingFound   = 4 // no matching directories
+)
+
+// codeError makes a command exit with a specific code. A nil err exits
+// without printing anything.
+type codeError struct {
+	code int
+	err  error
+}
+
+func (e *codeError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("exit status %d", e.code)
+	}
+	return e.err.Error()
+}
+
+func (e *codeError) Unwrap() error { return e.err }
+
+// Errors for outcomes that aren't failures but still need a distinct code
+var (
+	errNothingFound = &codeError{code: exitNothingFound}
+	errAborted      = &codeError{code: exitAborted}
+)
+
+// exitCodeOf returns the exit code for err without reporting it
+func exitCodeOf(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	if errors.Is(err, terminal.InterruptErr) || errors.Is(err, context.Canceled) {
+		return exitAborted
+	}
+	var ce *codeError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+	return exitError
+}
+
+// exitCode reports err to the user and returns the matching exit code
+func exitCode(err error) int {
+	code := exitCodeOf(err)
+
+	// Ctrl+C inside a prompt and codes without a message need no report
+	var ce *codeError
+	if code == exitOK || errors.Is(err, terminal.InterruptErr) || errors.As(err, &ce) && ce.err == nil {
+		return code
+	}
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "Interrupted.")
+		return code
+	}
+	printError("Error:", err)
+	return code
+}
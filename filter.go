@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Approximate lengths of calendar units used by age filters
+const (
+	day   = 24 * time.Hour
+	week  = 7 * day
+	month = 30 * day
+	year  = 365 * day
+)
+
+// ageValue is a flag.Value accepting ages such as "90d", "2w", "6m" or "1y"
+type ageValue time.Duration
+
+func (a *ageValue) String() string {
+	d := time.Duration(*a)
+	switch {
+	case d == 0:
+		return ""
+	case d%year == 0:
+		return fmt.Sprintf("%dy", d/year)
+	case d%week == 0:
+		return fmt.Sprintf("%dw", d/week)
+	case d%day == 0:
+		return fmt.Sprintf("%dd", d/day)
+	}
+	return fmt.Sprintf("%dh", d/time.Hour)
+}
+
+func (a *ageValue) Set(s string) error {
+	d, err := parseAge(s)
+	if err != nil {
+		return err
+	}
+	*a = ageValue(d)
+	return nil
+}
+
+// parseAge parses a number followed by a unit: h (hours), d (days),
+// w (weeks), m (months) or y (years)
+func parseAge(s string) (time.Duration, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	if len(s) < 2 {
+		return 0, fmt.Errorf("invalid age %q (expected e.g. 90d, 2w, 6m)", s)
+	}
+
+	units := map[byte]time.Duration{'h': time.Hour, 'd': day, 'w': week, 'm': month, 'y': year}
+	unit, ok := units[s[len(s)-1]]
+	if !ok {
+		return 0, fmt.Errorf("invalid age unit in %q (use h, d, w, m or y)", s)
+	}
+
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid age %q (expected e.g. 90d, 2w, 6m)", s)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// sizeValue is a flag.Value accepting sizes such as "500KB", "100MB" or "1.5GB"
+type sizeValue int64
+
+func (s *sizeValue) String() string {
+	if *s == 0 {
+		return ""
+	}
+	return formatSizeAs(int64(*s), sizeBinary) // parseSize reads powers of 1024
+}
+
+func (s *sizeValue) Set(v string) error {
+	n, err := parseSize(v)
+	if err != nil {
+		return err
+	}
+	*s = sizeValue(n)
+	return nil
+}
+
+// parseSize parses a size with an optional B, KB, MB, GB or TB suffix.
+// Units are powers of 1024 to match how sizes are displayed.
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	num := strings.TrimRight(s, "KMGTPIB ")
+	unit := strings.TrimSpace(s[len(num):])
+	unit = strings.Replace(unit, "I", "", 1)
+
+	multipliers := map[string]float64{
+		"": 1, "B": 1,
+		"K": 1 << 10, "KB": 1 << 10,
+		"M": 1 << 20, "MB": 1 << 20,
+		"G": 1 << 30, "GB": 1 << 30,
+		"T": 1 << 40, "TB": 1 << 40,
+		"P": 1 << 50, "PB": 1 << 50,
+	}
+	mult, ok := multipliers[unit]
+	n, err := strconv.ParseFloat(num, 64)
+	if !ok || err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q (expected e.g. 500KB, 100MB, 1.5GB)", s)
+	}
+	return int64(n * mult), nil
+}
+
+// predicate is a named per-directory filter
+type predicate struct {
+	name string
+	keep func(Directory) bool
+}
+
+// filterSet is the selection engine shared by every command, interactive or
+// not: it applies predicates, then list-level limits, then ordering
+type filterSet struct {
+	predicates []predicate
+	keepRecent int
+	top        int
+	sortBy     string
+	reverse    bool
+}
+
+// newFilterSet builds the filters requested by opts
+func newFilterSet(opts *options) (*filterSet, error) {
+	if _, ok := sortOrders[opts.sortBy]; !ok {
+		return nil, fmt.Errorf("unknown --sort key %q (use size, path or age)", opts.sortBy)
+	}
+
+	if opts.keepRecent < 0 {
+		return nil, fmt.Errorf("--keep-recent must not be negative")
+	}
+
+	f := &filterSet{keepRecent: opts.keepRecent, top: opts.top, sortBy: opts.sortBy, reverse: opts.reverse}
+	if opts.noSize {
+		if opts.minSize > 0 || opts.top > 0 {
+			return nil, fmt.Errorf("--min-size and --top need sizes and can't be combined with --no-size")
+		}
+		if f.sortBy == "size" {
+			f.sortBy = "path"
+		}
+	}
+	if opts.minSize > 0 {
+		f.add("min-size", minSize(int64(opts.minSize)))
+	}
+	if opts.olderThan > 0 {
+		f.add("older-than", olderThan(time.Duration(opts.olderThan)))
+	}
+	if len(opts.pinned) > 0 {
+		f.add("pinned", notPinned(opts.pinned))
+	}
+	return f, nil
+}
+
+// add appends a predicate to the set
+func (f *filterSet) add(name string, keep func(Directory) bool) {
+	f.predicates = append(f.predicates, predicate{name: name, keep: keep})
+}
+
+// apply returns the directories passing every filter, in the requested order
+func (f *filterSet) apply(dirs []Directory) []Directory {
+	// The most recent projects are protected before any other filter runs,
+	// so they stay out even when nothing else would match
+	predicates := f.predicates
+	if f.keepRecent > 0 {
+		recent := predicate{name: "keep-recent", keep: notRecent(dirs, f.keepRecent)}
+		predicates = append([]predicate{recent}, predicates...)
+	}
+
+	var kept []Directory
+	for _, dir := range dirs {
+		if keep(dir, predicates) {
+			kept = append(kept, dir)
+		}
+	}
+
+	if f.top > 0 {
+		kept = largest(kept, f.top)
+	}
+	sortDirectories(kept, f.sortBy, f.reverse)
+	return kept
+}
+
+// keep reports whether dir passes every predicate
+func keep(dir Directory, predicates []predicate) bool {
+	for _, p := range predicates {
+		if !p.keep(dir) {
+			slog.Debug("filtered out", "path", dir.path, "filter", p.name)
+			return false
+		}
+	}
+	return true
+}
+
+// minSize keeps directories of at least n bytes
+func minSize(n int64) func(Directory) bool {
+	return func(dir Directory) bool { return dir.size >= n }
+}
+
+// olderThan keeps directories whose project hasn't been touched for at least age
+func olderThan(age time.Duration) func(Directory) bool {
+	cutoff := time.Now().Add(-age)
+	return func(dir Directory) bool { return dir.modTime.Before(cutoff) }
+}
+
+// largest returns the n largest directories
+func largest(dirs []Directory, n int) []Directory {
+	bySize := append([]Directory(nil), dirs...)
+	sortDirectories(bySize, "size", false)
+	if len(bySize) > n {
+		bySize = bySize[:n]
+	}
+	return bySize
+}
+
+// notRecent rejects directories belonging to the n most recently active
+// projects among dirs
+func notRecent(dirs []Directory, n int) func(Directory) bool {
+	latest := make(map[string]time.Time)
+	for _, dir := range dirs {
+		if t, ok := latest[dir.project]; !ok || dir.modTime.After(t) {
+			latest[dir.project] = dir.modTime
+		}
+	}
+	projects := make([]string, 0, len(latest))
+	for project := range latest {
+		projects = append(projects, project)
+	}
+	sort.Slice(projects, func(i, j int) bool {
+		return latest[projects[i]].After(latest[projects[j]])
+	})
+
+	recent := make(map[string]bool, n)
+	for _, project := range projects[:min(n, len(projects))] {
+		recent[project] = true
+	}
+	return func(dir Directory) bool {
+		return !recent[dir.project]
+	}
+}
+
+// untilFreed picks directories, oldest project first and larger first among
+// equally old ones, until the space deleting them frees reaches target. When
+// everything together is smaller, all directories are returned.
+func untilFreed(dirs []Directory, target int64) []Directory {
+	candidates := append([]Directory(nil), dirs...)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if !a.modTime.Equal(b.modTime) {
+			return a.modTime.Before(b.modTime)
+		}
+		return a.size > b.size
+	})
+
+	var freed int64
+	for i, dir := range candidates {
+		if freed >= target {
+			return candidates[:i]
+		}
+		freed += dir.unique
+	}
+	return candidates
+}
+
+// sortOrders maps --sort keys to their ordering: size (largest first), path
+// (alphabetical) or age (least recently active first)
+var sortOrders = map[string]func(a, b Directory) bool{
+	"size": func(a, b Directory) bool { return a.size > b.size },
+	"path": func(a, b Directory) bool { return a.path < b.path },
+	"age":  func(a, b Directory) bool { return a.modTime.Before(b.modTime) },
+}
+
+// sortDirectories orders dirs in place using the named sort order
+func sortDirectories(dirs []Directory, key string, reverse bool) error {
+	less, ok := sortOrders[key]
+	if !ok {
+		return fmt.Errorf("unknown sort key %q (use size, path or age)", key)
+	}
+
+	sort.SliceStable(dirs, func(i, j int) bool {
+		if reverse {
+			return less(dirs[j], dirs[i])
+		}
+		return less(dirs[i], dirs[j])
+	})
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: "12h", want: 12 * time.Hour},
+		{in: "90d", want: 90 * day},
+		{in: "2W", want: 2 * week},
+		{in: " 6m ", want: 6 * month},
+		{in: "1y", want: year},
+		{in: "0d", want: 0},
+		{in: "d", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "90", wantErr: true},
+		{in: "90x", wantErr: true},
+		{in: "-1d", wantErr: true},
+		{in: "1.5d", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseAge(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAge(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseAge(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "0", want: 0},
+		{in: "512", want: 512},
+		{in: "512B", want: 512},
+		{in: "500KB", want: 500 << 10},
+		{in: "100mb", want: 100 << 20},
+		{in: "100MiB", want: 100 << 20},
+		{in: "10 MB", want: 10 << 20},
+		{in: "1.5GB", want: 3 << 29},
+		{in: "2T", want: 2 << 40},
+		{in: "", wantErr: true},
+		{in: "MB", wantErr: true},
+		{in: "-1MB", wantErr: true},
+		{in: "10XB", wantErr: true},
+		{in: "ten", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseSize(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSize(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseSize(%q) = %d, want %d", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotRecent(t *testing.T) {
+	now := time.Now()
+	dirs := []Directory{
+		{path: "a/node_modules", project: "a", modTime: now.Add(-time.Hour)},
+		{path: "b/node_modules", project: "b", modTime: now.Add(-3 * time.Hour)},
+		{path: "b/.yarn/cache", project: "b", modTime: now.Add(-30 * time.Minute)},
+		{path: "c/node_modules", project: "c", modTime: now.Add(-10 * time.Hour)},
+	}
+	tests := []struct {
+		name string
+		n    int
+		want []string // paths kept
+	}{
+		{name: "none", n: 0, want: []string{"a/node_modules", "b/node_modules", "b/.yarn/cache", "c/node_modules"}},
+		{name: "latest target counts for the project", n: 1, want: []string{"a/node_modules", "c/node_modules"}},
+		{name: "two", n: 2, want: []string{"c/node_modules"}},
+		{name: "more than there are", n: 5, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keep := notRecent(dirs, tt.n)
+			var got []string
+			for _, dir := range dirs {
+				if keep(dir) {
+					got = append(got, dir.path)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("notRecent(%d) kept %q, want %q", tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUntilFreed(t *testing.T) {
+	now := time.Now()
+	dirs := []Directory{
+		{path: "new", modTime: now, size: 30, unique: 30},
+		{path: "old", modTime: now.Add(-3 * time.Hour), size: 10, unique: 10},
+		{path: "small", modTime: now.Add(-time.Hour), size: 5, unique: 5},
+		{path: "large", modTime: now.Add(-time.Hour), size: 50, unique: 20}, // partly shared
+	}
+	tests := []struct {
+		name   string
+		target int64
+		want   []string
+	}{
+		{name: "nothing to free", target: 0, want: nil},
+		{name: "oldest alone", target: 10, want: []string{"old"}},
+		{name: "larger first when equally old", target: 11, want: []string{"old", "large"}},
+		{name: "counts unique size", target: 31, want: []string{"old", "large", "small"}},
+		{name: "exactly everything", target: 65, want: []string{"old", "large", "small", "new"}},
+		{name: "more than everything", target: 1000, want: []string{"old", "large", "small", "new"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, dir := range untilFreed(dirs, tt.target) {
+				got = append(got, dir.path)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("untilFreed(%d) = %q, want %q", tt.target, got, tt.want)
+			}
+		})
+	}
+}
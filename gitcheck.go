@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	gitOnce      sync.Once
+	gitAvailable bool
+)
+
+// insideGitRepo reports whether path lies in a git working tree
+func insideGitRepo(path string) bool {
+	for dir := path; ; {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false
+		}
+		dir = parent
+	}
+}
+
+// gitState returns how many files below path git tracks and whether any of
+// them have uncommitted changes. Outside a repository, or without git, both
+// are zero.
+func gitState(ctx context.Context, path string) (tracked int, dirty bool) {
+	gitOnce.Do(func() {
+		_, err := exec.LookPath("git")
+		gitAvailable = err == nil
+	})
+	if !gitAvailable || !insideGitRepo(path) {
+		return 0, false
+	}
+
+	// Run from the parent, path itself may be ignored or even excluded
+	cmd := exec.CommandContext(ctx, "git", "ls-files", "-z", "--", filepath.Base(path))
+	cmd.Dir = filepath.Dir(path)
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, false
+	}
+	tracked = bytes.Count(out, []byte{0})
+	if tracked == 0 {
+		return 0, false
+	}
+
+	cmd = exec.CommandContext(ctx, "git", "status", "--porcelain", "--untracked-files=no", "--", filepath.Base(path))
+	cmd.Dir = filepath.Dir(path)
+	if out, err = cmd.Output(); err == nil {
+		dirty = bufio.NewScanner(bytes.NewReader(out)).Scan()
+	}
+	return tracked, dirty
+}
+
+// gitNote describes the git state of a directory for the listing, or ""
+func gitNote(tracked int, dirty bool) string {
+	if tracked == 0 {
+		return ""
+	}
+	note := fmt.Sprintf("⚠️  git tracks %d files here", tracked)
+	if tracked == 1 {
+		note = "⚠️  git tracks a file here"
+	}
+	if dirty {
+		note += " with uncommitted changes"
+	}
+	return note
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// globPattern is a compiled path glob. Besides the usual *, ? and [...]
+// wildcards it understands ** to match any number of path components.
+type globPattern struct {
+	raw      string
+	re       *regexp.Regexp
+	baseOnly bool   // pattern has no separator and is matched against the base name
+	prefix   string // literal leading directory of the pattern, in slash form
+}
+
+// globList is a set of patterns where any match counts
+type globList []globPattern
+
+// expandHome replaces a leading ~ with the user's home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// compileGlob compiles a glob pattern. Patterns containing a separator are
+// made absolute so they can be matched against absolute paths, unless they
+// start with ** and may therefore match anywhere.
+func compileGlob(pattern string) (globPattern, error) {
+	g := globPattern{raw: pattern}
+
+	p := expandHome(pattern)
+	if !strings.ContainsAny(p, `/\`) {
+		g.baseOnly = true
+	} else if !filepath.IsAbs(p) && !strings.HasPrefix(p, "**") {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return g, err
+		}
+		p = abs
+	}
+	p = filepath.ToSlash(p)
+
+	// A trailing /** also matches the directory itself
+	trailing := strings.HasSuffix(p, "/**")
+	p = strings.TrimSuffix(p, "/**")
+	if !g.baseOnly {
+		g.prefix = literalPrefix(p)
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch c {
+		case '*':
+			if i+1 < len(p) && p[i+1] == '*' {
+				// "**/" matches zero or more whole components
+				if i+2 < len(p) && p[i+2] == '/' {
+					b.WriteString("(?:.*/)?")
+					i += 2
+				} else {
+					b.WriteString(".*")
+					i++
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(p[i:], ']')
+			if end < 0 {
+				return g, fmt.Errorf("invalid pattern %q: unterminated [", pattern)
+			}
+			class := p[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if trailing {
+		b.WriteString("(?:/.*)?")
+	}
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return g, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	g.re = re
+	return g, nil
+}
+
+// literalPrefix returns the directory part of p before its first wildcard
+func literalPrefix(p string) string {
+	meta := strings.IndexAny(p, "*?[")
+	if meta < 0 {
+		return p
+	}
+	slash := strings.LastIndexByte(p[:meta], '/')
+	if slash < 0 {
+		return ""
+	}
+	return p[:slash]
+}
+
+// compileGlobs compiles every pattern, failing on the first invalid one
+func compileGlobs(patterns []string) (globList, error) {
+	list := make(globList, 0, len(patterns))
+	for _, p := range patterns {
+		g, err := compileGlob(p)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, g)
+	}
+	return list, nil
+}
+
+// match reports whether path matches the pattern
+func (g globPattern) match(path string) bool {
+	if g.baseOnly {
+		return g.re.MatchString(filepath.Base(path))
+	}
+	return g.re.MatchString(filepath.ToSlash(path))
+}
+
+// mayMatchUnder reports whether dir or anything below it could match
+func (g globPattern) mayMatchUnder(dir string) bool {
+	if g.prefix == "" {
+		return true
+	}
+	dir = filepath.ToSlash(dir)
+	return isWithin(dir, g.prefix) || isWithin(g.prefix, dir)
+}
+
+// isWithin reports whether path equals dir or lies below it (slash form)
+func isWithin(path, dir string) bool {
+	if path == dir || dir == "/" {
+		return true
+	}
+	return strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// match reports whether path matches any pattern in the list
+func (l globList) match(path string) bool {
+	for _, g := range l {
+		if g.match(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSubtree reports whether path or any of its parents matches a pattern
+func (l globList) matchSubtree(path string) bool {
+	for {
+		if l.match(path) {
+			return true
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return false
+		}
+		path = parent
+	}
+}
+
+// mayMatchUnder reports whether any pattern could match dir or below it
+func (l globList) mayMatchUnder(dir string) bool {
+	for _, g := range l {
+		if g.mayMatchUnder(dir) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// expandGlobal expands ~ and environment variables in a global cache path.
+// It fails when a variable is unset, since the remaining path would point
+// somewhere else entirely.
+func expandGlobal(raw string) (string, bool) {
+	ok := true
+	path := os.Expand(expandHome(raw), func(name string) string {
+		value := os.Getenv(name)
+		if value == "" {
+			ok = false
+		}
+		return value
+	})
+	return filepath.Clean(path), ok
+}
+
+// globalDirectories sizes the global caches of the given detectors that
+// exist on this machine. Global caches belong to no project, so their age is
+// the modification time of the cache directory itself.
+func globalDirectories(ctx context.Context, detectors []*detector, scan scanOptions) []Directory {
+	var dirs []Directory
+	for _, d := range detectors {
+		for _, raw := range d.global {
+			path, ok := expandGlobal(raw)
+			if !ok {
+				continue
+			}
+			info, err := os.Lstat(path)
+			if err != nil || !info.IsDir() {
+				continue
+			}
+
+			dir := Directory{path: path, kind: d.name, global: true, modTime: info.ModTime(), stat: info}
+			if !scan.skipSize {
+				size, unique, err := calculateDirSize(ctx, path, scan.diskUsage, scan.errors)
+				if ctx.Err() != nil {
+					return dirs
+				}
+				if err != nil {
+					slog.Info("skipping global cache that could not be sized", "path", path, "err", err)
+					scan.errors.add(path, err)
+					continue
+				}
+				dir.size, dir.unique, dir.sized = size, unique, true
+			}
+			slog.Debug("found global cache", "path", path, "size", dir.size)
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
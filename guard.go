@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultRecentDays is how recently a project may have changed before
+// deleting its directories needs extra confirmation
+const defaultRecentDays = 7
+
+// guardRoot refuses to delete anything as root or Administrator without
+// --allow-root: with every file readable, a scan of / and a careless select
+// all could take the whole system with it
+func guardRoot(opts *options) error {
+	if opts.allowRoot || opts.dryRun || !isPrivileged() {
+		return nil
+	}
+	return fmt.Errorf("refusing to run as root or Administrator, pass --allow-root if you really mean to")
+}
+
+// checkPermissions finds the selected directories that can't be deleted
+// because they or their parents aren't writable, reports all of them at once
+// and returns the others
+func checkPermissions(selected []Directory) ([]Directory, int) {
+	var (
+		kept     []Directory
+		problems []string
+	)
+	for _, dir := range selected {
+		var err error
+		for _, path := range []string{filepath.Dir(dir.path), dir.path} {
+			if err = writable(path); err != nil {
+				problems = append(problems, fmt.Sprintf("  %s: %v", path, err))
+				break
+			}
+		}
+		if err == nil {
+			kept = append(kept, dir)
+		}
+	}
+	if len(problems) > 0 {
+		printWarning("%d of the selected directories can't be deleted with your permissions, leaving them out:\n%s", len(problems), strings.Join(problems, "\n"))
+	}
+	return kept, len(problems)
+}
+
+// warnSynced points out the selected directories inside cloud-synced
+// folders, where a deletion spreads to every synced device and makes the
+// client upload the change file by file
+func warnSynced(selected []Directory) {
+	var paths []string
+	for _, dir := range selected {
+		if dir.synced != "" {
+			paths = append(paths, fmt.Sprintf("  %s (%s)", dir.path, dir.synced))
+		}
+	}
+	if len(paths) > 0 {
+		printWarning("%d of the selected directories are in cloud-synced folders, deleting them also deletes them on your other devices:\n%s", len(paths), strings.Join(paths, "\n"))
+	}
+}
+
+// splitRecent separates the directories whose project changed within the
+// last days days from the rest. Global caches belong to no project.
+func splitRecent(dirs []Directory, days int) (recent, rest []Directory) {
+	cutoff := time.Now().Add(-time.Duration(days) * day)
+	for _, dir := range dirs {
+		if !dir.global && dir.modTime.After(cutoff) {
+			recent = append(recent, dir)
+		} else {
+			rest = append(rest, dir)
+		}
+	}
+	return recent, rest
+}
+
+// guardRecent keeps the directories of recently active projects out of a
+// deletion unless the user confirms them once more. Without a prompt, as
+// with --yes or --free, they are always left out.
+func guardRecent(selected []Directory, opts *options) ([]Directory, error) {
+	if opts.allowRecent || opts.recentDays <= 0 {
+		return selected, nil
+	}
+	recent, rest := splitRecent(selected, opts.recentDays)
+	if len(recent) == 0 {
+		return selected, nil
+	}
+
+	var paths []string
+	for _, dir := range recent {
+		paths = append(paths, fmt.Sprintf("  %s (last change %s)", dir.path, dir.modTime.Format("2006-01-02 15:04")))
+	}
+	printWarning("%d of the selected projects changed in the last %d days:\n%s", len(recent), opts.recentDays, strings.Join(paths, "\n"))
+
+	if opts.yes || opts.free > 0 || opts.dryRun {
+		printWarning("leaving them out, pass --allow-recent to delete them anyway")
+		return rest, nil
+	}
+	confirm, err := askConfirm("Delete the directories of these active projects too?")
+	if err != nil {
+		return nil, fmt.Errorf("during confirmation: %w", err)
+	}
+	if confirm {
+		return selected, nil
+	}
+	return rest, nil
+}
+
+// guardProtected removes directories covered by protected_paths from a
+// deletion, however they were selected. A directory is covered when it lies
+// below a pattern's match or contains the directory a pattern names.
+func guardProtected(selected []Directory, opts *options) ([]Directory, error) {
+	if len(opts.protected) == 0 {
+		return selected, nil
+	}
+	protected, err := compileGlobs(opts.protected)
+	if err != nil {
+		return nil, fmt.Errorf("parsing protected_paths: %w", err)
+	}
+
+	var kept []Directory
+outer:
+	for _, dir := range selected {
+		for _, g := range protected {
+			if (globList{g}).matchSubtree(dir.path) || (g.prefix != "" && isWithin(g.prefix, filepath.ToSlash(dir.path))) {
+				printWarning("refusing to delete %s: it is protected by %q in protected_paths", dir.path, g.raw)
+				continue outer
+			}
+		}
+		kept = append(kept, dir)
+	}
+	return kept, nil
+}
+
+// process is a running process
+type process struct {
+	pid  int
+	name string
+}
+
+func (p process) String() string { return fmt.Sprintf("%s (pid %d)", p.name, p.pid) }
+
+// procPath is a file a process has open, or its working directory
+type procPath struct {
+	proc process
+	path string
+}
+
+// processesUsing returns, per directory, the processes with files open
+// inside it or running in it
+func processesUsing(dirs []Directory) (map[string][]process, error) {
+	open, err := openPaths()
+	if err != nil {
+		return nil, err
+	}
+	self := os.Getpid()
+	users := make(map[string][]process)
+	for _, dir := range dirs {
+		seen := make(map[int]bool)
+		for _, p := range open {
+			if p.proc.pid == self || seen[p.proc.pid] {
+				continue
+			}
+			if p.path == dir.path || strings.HasPrefix(p.path, dir.path+string(filepath.Separator)) {
+				seen[p.proc.pid] = true
+				users[dir.path] = append(users[dir.path], p.proc)
+			}
+		}
+	}
+	return users, nil
+}
+
+// guardInUse keeps directories that running processes, such as dev servers
+// or watchers, have files open in out of a deletion unless the user
+// confirms them once more. Without a prompt they are always left out.
+func guardInUse(selected []Directory, opts *options) ([]Directory, error) {
+	if opts.allowInUse {
+		return selected, nil
+	}
+	users, err := processesUsing(selected)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return selected, nil // no way to tell on this system
+	}
+	if err != nil {
+		printWarning("could not check for processes using the directories: %v", err)
+		return selected, nil
+	}
+	if len(users) == 0 {
+		return selected, nil
+	}
+
+	var busy, rest []Directory
+	var lines []string
+	for _, dir := range selected {
+		procs, ok := users[dir.path]
+		if !ok {
+			rest = append(rest, dir)
+			continue
+		}
+		busy = append(busy, dir)
+		var names []string
+		for _, p := range procs {
+			names = append(names, p.String())
+		}
+		lines = append(lines, fmt.Sprintf("  %s: %s", dir.path, strings.Join(names, ", ")))
+	}
+	printWarning("%d of the selected directories are in use by running processes:\n%s", len(busy), strings.Join(lines, "\n"))
+
+	if opts.yes || opts.free > 0 || opts.dryRun {
+		printWarning("leaving them out, stop those processes or pass --allow-in-use to delete them anyway")
+		return rest, nil
+	}
+	confirm, err := askConfirm("Delete the directories in use too? Running apps may break.")
+	if err != nil {
+		return nil, fmt.Errorf("during confirmation: %w", err)
+	}
+	if confirm {
+		return selected, nil
+	}
+	return rest, nil
+}
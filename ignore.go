@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ignoreFileName is the per-directory file listing paths that must never be
+// offered for deletion, in gitignore syntax
+const ignoreFileName = ".cleanmodulesignore"
+
+// ignoreRule is a single line of an ignore file
+type ignoreRule struct {
+	glob    globPattern
+	negate  bool // line started with !
+	dirOnly bool // line ended with /
+}
+
+// ignoreFile holds the rules of one .cleanmodulesignore, relative to its directory
+type ignoreFile struct {
+	dir   string
+	rules []ignoreRule
+}
+
+// loadIgnoreFile parses the ignore file in dir, returning nil if there is none
+func loadIgnoreFile(dir string) (*ignoreFile, error) {
+	f, err := os.Open(filepath.Join(dir, ignoreFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ignore := &ignoreFile{dir: dir}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			line = line[1:] // escaped leading # or !
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+
+		// Patterns without an inner slash match at any depth, others are
+		// anchored to the directory holding the ignore file
+		pattern := filepath.ToSlash(dir)
+		if strings.Contains(line, "/") {
+			pattern += "/" + strings.TrimPrefix(line, "/")
+		} else {
+			pattern += "/**/" + line
+		}
+		if rule.glob, err = compileGlob(pattern); err != nil {
+			slog.Debug("skipping malformed ignore rule", "file", f.Name(), "err", err)
+			continue // skip malformed lines like git does
+		}
+		ignore.rules = append(ignore.rules, rule)
+	}
+	return ignore, scanner.Err()
+}
+
+// match reports whether any rule applies to path and, if so, whether the
+// last applicable rule ignores it
+func (f *ignoreFile) match(path string, isDir bool) (matched, ignored bool) {
+	for _, rule := range f.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.glob.match(path) {
+			matched, ignored = true, !rule.negate
+		}
+	}
+	return matched, ignored
+}
+
+// ignoreSet caches the ignore files found while walking a tree
+type ignoreSet struct {
+	root  string
+	mu    sync.RWMutex // the walk visits directories concurrently
+	files map[string]*ignoreFile
+}
+
+func newIgnoreSet(root string) *ignoreSet {
+	return &ignoreSet{root: root, files: make(map[string]*ignoreFile)}
+}
+
+// visit loads the ignore file of a directory the walk has entered
+func (s *ignoreSet) visit(dir string) {
+	s.mu.RLock()
+	_, ok := s.files[dir]
+	s.mu.RUnlock()
+	if ok {
+		return
+	}
+	f, err := loadIgnoreFile(dir)
+	if err != nil {
+		slog.Info("could not read ignore file", "dir", dir, "err", err)
+		f = nil
+	}
+	s.mu.Lock()
+	s.files[dir] = f
+	s.mu.Unlock()
+}
+
+// ignored reports whether path is ignored by an ignore file in one of its
+// parent directories. Deeper files take precedence, as with .gitignore.
+func (s *ignoreSet) ignored(path string, isDir bool) bool {
+	if path == s.root {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if f := s.files[dir]; f != nil {
+			if matched, ignored := f.match(path, isDir); matched {
+				return ignored
+			}
+		}
+		if dir == s.root || dir == filepath.Dir(dir) {
+			return false
+		}
+	}
+}
@@ -5,7 +5,7 @@ const path = require('path')
 const binaryPath = path.join(__dirname, 'bin', 'drop-modules')
 const currentDir = process.cwd()
 
-const child = spawn(binaryPath, [currentDir], {
+const child = spawn(binaryPath, process.argv.slice(2), {
   stdio: 'inherit',
   cwd: currentDir,
 })
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// inodeKey identifies a file across its hard links
+type inodeKey struct {
+	dev, ino uint64
+}
+
+// hardLinks can't see links here, so every file counts as unlinked
+func hardLinks(info os.FileInfo) (inodeKey, uint64, bool) {
+	return inodeKey{}, 1, false
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// inodeKey identifies a file across its hard links
+type inodeKey struct {
+	dev, ino uint64
+}
+
+// hardLinks returns the inode of info and its link count
+func hardLinks(info os.FileInfo) (inodeKey, uint64, bool) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return inodeKey{dev: uint64(st.Dev), ino: uint64(st.Ino)}, uint64(st.Nlink), true
+	}
+	return inodeKey{}, 1, false
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errLocked means another clean-modules process holds the lock
+var errLocked = errors.New("locked")
+
+// lockRun makes sure only one clean-modules process at a time changes
+// anything, so two runs don't race on the same directories. When another one
+// holds the lock it fails naming its PID, or with --wait waits for it. The
+// returned function releases the lock.
+func lockRun(ctx context.Context, opts *options) (func(), error) {
+	if opts.dryRun {
+		return func() {}, nil // changes nothing
+	}
+	path, err := statePath("lock")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	waiting := false
+	for {
+		err = tryLock(f)
+		if !errors.Is(err, errLocked) {
+			break
+		}
+		holder := "unknown pid"
+		if data, rerr := os.ReadFile(path); rerr == nil && len(strings.TrimSpace(string(data))) > 0 {
+			holder = "pid " + strings.TrimSpace(string(data))
+		}
+		if !opts.wait {
+			f.Close()
+			return nil, fmt.Errorf("another clean-modules run (%s) is in progress, wait for it to finish or pass --wait", holder)
+		}
+		if !waiting {
+			fmt.Fprintf(opts.progress(), "Waiting for another clean-modules run (%s) to finish ⏳\n", holder)
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("locking %s: %w", path, err)
+	}
+
+	f.Truncate(0)
+	f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return func() {
+		f.Truncate(0)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package main
+
+import "os"
+
+// tryLock does nothing where there are no advisory file locks
+func tryLock(f *os.File) error {
+	return nil
+}
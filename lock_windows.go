@@ -0,0 +1,36 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// LockFileEx flags and errors
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	errorLockViolation      = syscall.Errno(33)
+)
+
+var lockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+// tryLock takes an exclusive lock on f without waiting, returning errLocked
+// when another process holds it. The lock goes away with the process. It
+// covers a byte far past the end, as locked bytes can't be read and others
+// need to read the PID in the file.
+func tryLock(f *os.File) error {
+	var ol syscall.Overlapped
+	ol.OffsetHigh = 0x7fffffff
+	ret, _, err := lockFileEx.Call(f.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	if ret != 0 {
+		return nil
+	}
+	if errors.Is(err, errorLockViolation) {
+		return errLocked
+	}
+	return err
+}
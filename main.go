@@ -1,193 +1,116 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
 	"os"
-	"path/filepath"
-	"sync"
-	"time"
-
-	"github.com/AlecAivazis/survey/v2"
+	"os/signal"
+	"syscall"
 )
 
-// Directory represents a node_modules directory with its size
-type Directory struct {
-	path string
-	size int64
+// usage prints the top-level help listing all commands
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: clean-modules [command] [flags] [root...]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'clean-modules <command> -h' for the flags of a command.\n")
 }
 
-// calculateDirSize calculates the total size of a directory
-func calculateDirSize(path string) (int64, error) {
-	var size int64
-	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() {
-			size += info.Size()
-		}
-		return nil
-	})
-	return size, err
+// setupLogging configures the default structured logger for --verbose and --debug
+func setupLogging(opts *options) {
+	level := slog.LevelWarn
+	switch {
+	case opts.debug:
+		level = slog.LevelDebug
+	case opts.verbose:
+		level = slog.LevelInfo
+	}
+	handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+	slog.SetDefault(slog.New(handler))
 }
 
-// findNodeModules finds all node_modules directories concurrently
-func findNodeModules(root string) ([]Directory, error) {
-	var (
-		nodeModules []Directory
-		mutex       sync.Mutex
-		wg          sync.WaitGroup
-	)
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
 
-	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil // Skip errors and continue walking
+// run executes the command line and returns the process exit code
+func run(args []string) int {
+	// Without a known command name, fall back to the interactive clean flow
+	cmd := findCommand("clean")
+	if len(args) > 0 {
+		switch args[0] {
+		case "help", "-h", "-help", "--help":
+			usage()
+			return exitOK
+		case "-version", "--version":
+			args[0] = "version"
 		}
-
-		if info.IsDir() && info.Name() == "node_modules" {
-			wg.Add(1)
-			go func(p string) {
-				defer wg.Done()
-				size, err := calculateDirSize(p)
-				if err == nil {
-					mutex.Lock()
-					nodeModules = append(nodeModules, Directory{path: p, size: size})
-					mutex.Unlock()
-				}
-			}(path)
-			return filepath.SkipDir
+		if c := findCommand(args[0]); c != nil {
+			cmd = c
+			args = args[1:]
 		}
-		return nil
-	})
-
-	wg.Wait()
-	return nodeModules, err
-}
-
-// formatSize converts bytes to human readable format
-func formatSize(bytes int64) string {
-	const unit = 1024
-	if bytes < unit {
-		return fmt.Sprintf("%d B", bytes)
 	}
-	div, exp := int64(unit), 0
-	for n := bytes / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
-	}
-	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
-}
-
-// deleteDirectory deletes a directory with progress feedback
-func deleteDirectory(dir Directory) error {
-	start := time.Now()
-	err := os.RemoveAll(dir.path)
-	duration := time.Since(start)
 
-	if err != nil {
-		return fmt.Errorf("failed to delete %s: %w", dir.path, err)
+	var opts options
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: clean-modules %s [flags] %s\n\n%s\n\nFlags:\n", cmd.name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
 	}
-
-	fmt.Printf("Deleted [%s] (%s) in %s ✅\n",
-		dir.path,
-		formatSize(dir.size),
-		duration.Round(time.Millisecond))
-	return nil
-}
-
-func main() {
-	var root string
-	if len(os.Args) > 1 {
-		root = os.Args[1]
-	} else {
-		var err error
-		root, err = os.Getwd()
-		if err != nil {
-			fmt.Printf("Error getting current directory: %v\n", err)
-			return
+	cmd.flags(fs, &opts)
+
+	// Config and profile values become defaults that flags parsed below override
+	path, err := configPath()
+	if err == nil {
+		var cfg *fileConfig
+		if cfg, err = loadConfig(path); err == nil {
+			err = cfg.apply(&opts)
+		}
+		if err == nil {
+			err = cfg.applyProfile(profileFromArgs(cmd, args), &opts)
 		}
 	}
-	fmt.Printf("Scanning for node_modules in %s (this may take a moment)...\n", root)
-
-	// Find all node_modules directories with their sizes
-	dirs, err := findNodeModules(root)
 	if err != nil {
-		fmt.Printf("Error walking directory: %v\n", err)
-		return
+		return exitCode(fmt.Errorf("loading config: %w", err))
 	}
 
-	if len(dirs) == 0 {
-		fmt.Printf("No node_modules directories found in %s\n", root)
-		return
-	}
-
-	// Create options with sizes
-	var options []string
-	for _, dir := range dirs {
-		options = append(options, fmt.Sprintf("%s (%s)", dir.path, formatSize(dir.size)))
-	}
-
-	var selectedIndices []int
-	prompt := &survey.MultiSelect{
-		Message:  fmt.Sprintf("Found %d node_modules directories. Select directories to DELETE:", len(dirs)),
-		Options:  options,
-		PageSize: 50,
-	}
-
-	if err = survey.AskOne(prompt, &selectedIndices); err != nil {
-		fmt.Printf("Error during selection: %v\n", err)
-		return
-	}
-
-	if len(selectedIndices) == 0 {
-		fmt.Println("No directories selected for deletion.")
-		return
-	}
-
-	// Calculate total size to be deleted
-	var totalSize int64
-	for _, idx := range selectedIndices {
-		totalSize += dirs[idx].size
-	}
-
-	// Confirm deletion with total size
-	var confirm bool
-	confirmPrompt := &survey.Confirm{
-		Message: fmt.Sprintf("Are you sure you want to DELETE %d directories (total size: %s)? This cannot be undone!",
-			len(selectedIndices),
-			formatSize(totalSize)),
-	}
-
-	if err = survey.AskOne(confirmPrompt, &confirm); err != nil {
-		fmt.Printf("Error during confirmation: %v\n", err)
-		return
+	replaceConfiguredLists(fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitError // the flag package already printed the problem
 	}
 
-	if !confirm {
-		fmt.Println("Operation cancelled.")
-		return
+	setupLogging(&opts)
+	if err := setSizeFormat(opts.sizeFormat); err != nil {
+		return exitCode(err)
 	}
-
-	fmt.Printf("\nDeleting %d directories (total size: %s) ⏳\n", len(selectedIndices), formatSize(totalSize))
-
-	// Delete directories concurrently with a worker pool
-	const maxConcurrent = 3
-	semaphore := make(chan struct{}, maxConcurrent)
-	var deleteWg sync.WaitGroup
-
-	for _, idx := range selectedIndices {
-		deleteWg.Add(1)
-		go func(dir Directory) {
-			defer deleteWg.Done()
-			semaphore <- struct{}{}        // Acquire
-			defer func() { <-semaphore }() // Release
-
-			if err := deleteDirectory(dir); err != nil {
-				fmt.Printf("ERROR: %v\n", err)
-			}
-		}(dirs[idx])
+	if err := setColorMode(opts.color); err != nil {
+		return exitCode(err)
 	}
 
-	deleteWg.Wait()
-	fmt.Println("\nOperation completed! 🎉")
+	// The first Ctrl+C lets the command wind down, the second one kills it
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	finished, watched := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(watched)
+		select {
+		case <-ctx.Done():
+		case <-finished:
+			return
+		}
+		stop()
+		fmt.Fprintln(os.Stderr, "\nInterrupted, finishing what is in progress (press Ctrl+C again to quit at once)")
+	}()
+	err = cmd.run(ctx, &opts, fs.Args())
+	// Only stop once the watcher is gone, it would take that for Ctrl+C
+	close(finished)
+	<-watched
+	stop()
+	return exitCode(err)
 }
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Size formats selectable with --size-format
+const (
+	sizeBinary = "binary" // powers of 1024
+	sizeSI     = "si"     // powers of 1000
+	sizeBytes  = "bytes"  // exact byte counts
+)
+
+// sizeFormat is the format used by formatSize, set once from the command line
+var sizeFormat = sizeBinary
+
+// setSizeFormat selects the format used by formatSize
+func setSizeFormat(format string) error {
+	switch format {
+	case "":
+		sizeFormat = sizeBinary
+		return nil
+	case sizeBinary, sizeSI, sizeBytes:
+		sizeFormat = format
+		return nil
+	}
+	return fmt.Errorf("unknown --size-format %q (use binary, si or bytes)", format)
+}
+
+// formatSize converts bytes to the configured display format
+func formatSize(bytes int64) string {
+	return formatSizeAs(bytes, sizeFormat)
+}
+
+// formatSizeAs converts bytes to human readable format, or a plain byte count
+// for the bytes format
+func formatSizeAs(bytes int64, format string) string {
+	if format == sizeBytes {
+		return fmt.Sprintf("%d", bytes)
+	}
+
+	unit, prefixes := int64(1024), "KMGTPE"
+	if format == sizeSI {
+		unit, prefixes = 1000, "kMGTPE"
+	}
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := unit, 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), prefixes[exp])
+}
+
+// Output formats for scan results
+const (
+	formatText = "text"
+	formatJSON = "json"
+	formatCSV  = "csv"
+	formatYAML = "yaml"
+)
+
+// outputFormats maps format names to writers of scan results
+var outputFormats = map[string]func(w io.Writer, dirs []Directory) error{
+	formatText: writeTable,
+	formatJSON: writeJSON,
+	formatCSV:  writeCSV,
+	formatYAML: writeYAML,
+}
+
+// formatFromPath infers the output format from a file extension, defaulting to text
+func formatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return formatJSON
+	case ".csv":
+		return formatCSV
+	case ".yaml", ".yml":
+		return formatYAML
+	}
+	return formatText
+}
+
+// dirRecord is the machine-readable form of a Directory
+type dirRecord struct {
+	Path          string    `json:"path" yaml:"path"`
+	Size          *int64    `json:"size" yaml:"size"` // bytes, null with --no-size
+	SizeHuman     string    `json:"size_human,omitempty" yaml:"size_human,omitempty"`
+	UniqueSize    *int64    `json:"unique_size,omitempty" yaml:"unique_size,omitempty"` // bytes freed by deleting it
+	LastModified  time.Time `json:"last_modified" yaml:"last_modified"`
+	Project       string    `json:"project" yaml:"project"`
+	Type          string    `json:"type,omitempty" yaml:"type,omitempty"` // detector name
+	Manager       string    `json:"package_manager,omitempty" yaml:"package_manager,omitempty"`
+	Workspace     string    `json:"workspace,omitempty" yaml:"workspace,omitempty"` // monorepo root
+	Global        bool      `json:"global,omitempty" yaml:"global,omitempty"`
+	Notes         []string  `json:"notes,omitempty" yaml:"notes,omitempty"`
+	Restore       string    `json:"restore,omitempty" yaml:"restore,omitempty"`       // command that regenerates it
+	Restorable    *bool     `json:"restorable,omitempty" yaml:"restorable,omitempty"` // cheaply, see RestoreIssues
+	RestoreIssues []string  `json:"restore_issues,omitempty" yaml:"restore_issues,omitempty"`
+	GitTracked    int       `json:"git_tracked,omitempty" yaml:"git_tracked,omitempty"` // files git tracks below it
+	GitDirty      bool      `json:"git_dirty,omitempty" yaml:"git_dirty,omitempty"`     // tracked files have uncommitted changes
+	CloudSync     string    `json:"cloud_sync,omitempty" yaml:"cloud_sync,omitempty"`   // service syncing it
+	Risk          string    `json:"risk,omitempty" yaml:"risk,omitempty"`
+}
+
+// newDirRecord converts a Directory for structured output
+func newDirRecord(dir Directory) dirRecord {
+	record := dirRecord{
+		Path:         dir.path,
+		LastModified: dir.modTime,
+		Project:      dir.project,
+		Type:         dir.kind,
+		Manager:      dir.manager,
+		Workspace:    dir.workspace,
+		GitTracked:   dir.tracked,
+		GitDirty:     dir.dirty,
+		CloudSync:    dir.synced,
+		Global:       dir.global,
+		Notes:        dir.notes,
+		Restore:      dir.restoreHint(),
+		Risk:         dir.riskLevel(),
+	}
+	if dir.checked {
+		restorable := len(dir.issues) == 0
+		record.Restorable = &restorable
+		record.RestoreIssues = dir.issues
+	}
+	if dir.sized {
+		record.Size = &dir.size
+		record.SizeHuman = formatSize(dir.size)
+		record.UniqueSize = &dir.unique
+	}
+	return record
+}
+
+// newDirRecords converts dirs for structured output
+func newDirRecords(dirs []Directory) []dirRecord {
+	records := make([]dirRecord, 0, len(dirs))
+	for _, dir := range dirs {
+		records = append(records, newDirRecord(dir))
+	}
+	return records
+}
+
+// writeJSON writes the scan results as an indented JSON array
+func writeJSON(w io.Writer, dirs []Directory) error {
+	records := newDirRecords(dirs)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+// writeYAML writes the scan results as a YAML sequence
+func writeYAML(w io.Writer, dirs []Directory) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(newDirRecords(dirs)); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// writeCSV writes the scan results with a header row
+func writeCSV(w io.Writer, dirs []Directory) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"path", "size_bytes", "size", "last_modified", "project_name", "project", "type", "package_manager"})
+	for _, dir := range dirs {
+		var sizeBytes string
+		if dir.sized {
+			sizeBytes = strconv.FormatInt(dir.size, 10)
+		}
+		cw.Write([]string{
+			dir.path,
+			sizeBytes,
+			dir.sizeString(),
+			dir.modTime.Format(time.RFC3339),
+			filepath.Base(dir.project),
+			dir.project,
+			dir.kind,
+			dir.manager,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeTable prints one line per directory followed by the total size.
+// Directories of the same workspace are listed together below its root.
+func writeTable(w io.Writer, dirs []Directory) error {
+	for _, group := range groupWorkspaces(dirs) {
+		indent := ""
+		if len(group) > 1 {
+			size := fmt.Sprintf("%10s", totalSizeString(group))
+			fmt.Fprintf(w, "%s  %s (workspace, %d directories)\n", paintSize(w, sumSizes(group), size), group[0].workspace, len(group))
+			indent = "  "
+		}
+		for _, dir := range group {
+			writeTableRow(w, dir, indent)
+		}
+	}
+	if len(dirs) > 0 && !dirs[0].sized {
+		_, err := fmt.Fprintf(w, "\nFound %d directories\n", len(dirs))
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\nFound %d directories (total size: %s)\n", len(dirs), paint(w, ansiBold, formatSize(sumSizes(dirs))))
+	return err
+}
+
+// writeTableRow prints the line of one directory
+func writeTableRow(w io.Writer, dir Directory, indent string) {
+	// Pad before painting so escape codes don't break the alignment
+	size := fmt.Sprintf("%10s", dir.sizeString())
+	label := indent + dir.path
+	if dir.global {
+		label += " (global cache)"
+	}
+	if dir.manager != "" {
+		label += " (" + dir.manager + ")"
+	}
+	if dir.sized && dir.unique < dir.size {
+		label += fmt.Sprintf(" (%s unique, the rest is hard-linked elsewhere)", formatSize(dir.unique))
+	}
+	if len(dir.notes) > 0 {
+		label += " [" + strings.Join(dir.notes, "; ") + "]"
+	}
+	if mark := dir.restorability(); mark != "" {
+		label += " " + mark
+	}
+	fmt.Fprintf(w, "%s  %s\n", paintSize(w, dir.size, size), label)
+}
+
+// writeStats prints aggregate figures and an age breakdown for dirs
+func writeStats(w io.Writer, dirs []Directory) {
+	total := sumSizes(dirs)
+	largest, oldest := dirs[0], dirs[0]
+	for _, dir := range dirs {
+		if dir.size > largest.size {
+			largest = dir
+		}
+		if dir.modTime.Before(oldest.modTime) {
+			oldest = dir
+		}
+	}
+
+	fmt.Fprintf(w, "Directories:  %d\n", len(dirs))
+	fmt.Fprintf(w, "Total size:   %s\n", formatSize(total))
+	fmt.Fprintf(w, "Average size: %s\n", formatSize(total/int64(len(dirs))))
+	fmt.Fprintf(w, "Largest:      %s (%s)\n", largest.path, formatSize(largest.size))
+	fmt.Fprintf(w, "Oldest:       %s (last active %s)\n", oldest.path, oldest.modTime.Format("2006-01-02"))
+
+	buckets := []struct {
+		label string
+		age   time.Duration
+		count int
+		size  int64
+	}{
+		{label: "< 1 month", age: month},
+		{label: "1-3 months", age: 3 * month},
+		{label: "3-12 months", age: year},
+		{label: "> 1 year"},
+	}
+	for _, dir := range dirs {
+		age := time.Since(dir.modTime)
+		for i := range buckets {
+			if buckets[i].age == 0 || age < buckets[i].age {
+				buckets[i].count++
+				buckets[i].size += dir.size
+				break
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "\nBy project activity:\n")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, b := range buckets {
+		fmt.Fprintf(tw, "  %s\t%d\t%s\n", b.label, b.count, formatSize(b.size))
+	}
+	tw.Flush()
+}
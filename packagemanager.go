@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lockfiles maps lockfile names to the package manager that writes them, in
+// the order they are checked
+var lockfiles = []struct {
+	name    string
+	manager string
+}{
+	{"pnpm-lock.yaml", "pnpm"},
+	{"yarn.lock", "yarn"},
+	{"bun.lock", "bun"},
+	{"bun.lockb", "bun"},
+	{"package-lock.json", "npm"},
+	{"npm-shrinkwrap.json", "npm"},
+}
+
+// findLockfile returns the package manager and lockfile of a JavaScript
+// project, or empty strings when it has no lockfile. Workspace members
+// share the lockfile of their workspace root.
+func findLockfile(project string) (manager, lockfile string) {
+	for _, l := range lockfiles {
+		path := filepath.Join(project, l.name)
+		if _, err := os.Stat(path); err == nil {
+			return l.manager, path
+		}
+	}
+	if root := workspaceRoot(project); root != "" && root != project {
+		return findLockfile(root)
+	}
+	return "", ""
+}
+
+// detectPackageManager returns the package manager a JavaScript project
+// uses: the one named by the packageManager field of package.json (as used
+// by Corepack), else the one whose lockfile is present, else ""
+func detectPackageManager(project string) string {
+	if data, err := os.ReadFile(filepath.Join(project, "package.json")); err == nil {
+		var manifest struct {
+			PackageManager string `json:"packageManager"`
+		}
+		if json.Unmarshal(data, &manifest) == nil && manifest.PackageManager != "" {
+			// "pnpm@9.1.0+sha512..." names the manager before the @
+			name, _, _ := strings.Cut(manifest.PackageManager, "@")
+			switch name {
+			case "npm", "yarn", "pnpm", "bun":
+				return name
+			}
+		}
+	}
+	manager, _ := findLockfile(project)
+	return manager
+}
+
+// installRisk judges a node_modules against its project's lockfile. One
+// older than the lockfile is stale, so an install is pending anyway and
+// deleting it is low risk; without a lockfile the exact tree can't be
+// reproduced, which is high risk. Otherwise risk is "".
+func installRisk(project, nodeModules string) (risk, note string) {
+	_, lockfile := findLockfile(project)
+	if lockfile == "" {
+		if _, err := os.Stat(filepath.Join(project, "package.json")); err != nil {
+			return "", "" // not an npm-style project, nothing to judge
+		}
+		return riskHigh, "no lockfile, the exact install can't be reproduced"
+	}
+	lock, err := os.Stat(lockfile)
+	if err != nil {
+		return "", ""
+	}
+	// npm, yarn and pnpm rewrite these on every install; the directory's
+	// own mtime only changes when top-level entries do
+	installed, err := os.Stat(nodeModules)
+	for _, marker := range []string{".package-lock.json", ".yarn-integrity", ".modules.yaml", ".yarn-state.yml"} {
+		if info, merr := os.Stat(filepath.Join(nodeModules, marker)); merr == nil {
+			installed, err = info, nil
+			break
+		}
+	}
+	if err == nil && installed.ModTime().Before(lock.ModTime()) {
+		return riskLow, "older than " + filepath.Base(lockfile) + ", a reinstall is pending anyway"
+	}
+	return "", ""
+}
+
+// installCommand returns the command that reinstalls exactly what the
+// lockfile of project pins, or nil without a lockfile
+func installCommand(project string) []string {
+	if _, lockfile := findLockfile(project); lockfile == "" {
+		return nil
+	}
+	switch detectPackageManager(project) {
+	case "npm":
+		return []string{"npm", "ci"}
+	case "yarn":
+		// Yarn Berry projects carry a .yarnrc.yml; classic Yarn spells the flag differently
+		if _, err := os.Stat(filepath.Join(project, ".yarnrc.yml")); err == nil {
+			return []string{"yarn", "install", "--immutable"}
+		}
+		return []string{"yarn", "install", "--frozen-lockfile"}
+	case "pnpm":
+		return []string{"pnpm", "install", "--frozen-lockfile"}
+	case "bun":
+		return []string{"bun", "install", "--frozen-lockfile"}
+	}
+	return nil
+}
+
+// reinstallProjects runs the lockfile install command in the project of
+// every deleted node_modules and returns how many installs failed
+func reinstallProjects(ctx context.Context, results []deleteResult, progress io.Writer) int {
+	failed := 0
+	seen := make(map[string]bool)
+	for _, res := range results {
+		dir := res.dir
+		// One install at the root restores every member of a workspace
+		project := dir.project
+		if dir.workspace != "" {
+			project = dir.workspace
+		}
+		if res.err != nil || dir.kind != "node_modules" || dir.global || seen[project] {
+			continue
+		}
+		seen[project] = true
+
+		args := installCommand(project)
+		if args == nil {
+			printWarning("not reinstalling %s: it has no lockfile to install from", project)
+			continue
+		}
+		fmt.Fprintf(progress, "\nReinstalling %s with %s ⏳\n", project, strings.Join(args, " "))
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Dir = project
+		cmd.Stdout, cmd.Stderr = progress, os.Stderr
+		if err := cmd.Run(); err != nil {
+			printError("ERROR:", fmt.Errorf("reinstalling %s: %w", project, err))
+			failed++
+			continue
+		}
+		fmt.Fprintf(progress, "Reinstalled %s ✅\n", project)
+	}
+	return failed
+}
+
+// defaultRegistry is where npm-compatible managers download from unless an
+// .npmrc says otherwise
+const defaultRegistry = "https://registry.npmjs.org/"
+
+// registryOf returns the registry a project installs from, read from the
+// project's or the user's .npmrc
+func registryOf(project string) string {
+	home, _ := os.UserHomeDir()
+	for _, rc := range []string{filepath.Join(project, ".npmrc"), filepath.Join(home, ".npmrc")} {
+		f, err := os.Open(rc)
+		if err != nil {
+			continue
+		}
+		lines := bufio.NewScanner(f)
+		for lines.Scan() {
+			key, value, ok := strings.Cut(lines.Text(), "=")
+			if ok && strings.TrimSpace(key) == "registry" {
+				f.Close()
+				return strings.TrimSpace(value)
+			}
+		}
+		f.Close()
+	}
+	return defaultRegistry
+}
+
+// registryCheck is the outcome of asking one registry, made once per run
+type registryCheck struct {
+	once sync.Once
+	ok   bool
+}
+
+var (
+	registryMu     sync.Mutex
+	registryChecks = make(map[string]*registryCheck)
+)
+
+// reachable reports whether the registry answers an HTTP request within a
+// few seconds. Proxy settings from the environment are honored. Only callers
+// asking about the same registry wait for each other.
+func reachable(ctx context.Context, registry string) bool {
+	registryMu.Lock()
+	check, ok := registryChecks[registry]
+	if !ok {
+		check = &registryCheck{}
+		registryChecks[registry] = check
+	}
+	registryMu.Unlock()
+
+	check.once.Do(func() {
+		client := &http.Client{Timeout: 3 * time.Second}
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, registry, nil)
+		if err != nil {
+			return
+		}
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		check.ok = err == nil
+	})
+	return check.ok
+}
+
+// installScripts returns the top-level packages of nodeModules that run
+// install scripts or compile native code, which makes reinstalling slow
+func installScripts(nodeModules string) []string {
+	pkgs, _ := packageDirs(nodeModules)
+	var heavy []string
+	for _, p := range pkgs {
+		data, err := os.ReadFile(filepath.Join(p.Path, "package.json"))
+		if err != nil {
+			continue
+		}
+		var m struct {
+			Scripts map[string]string `json:"scripts"`
+		}
+		json.Unmarshal(data, &m)
+		_, gyp := os.Stat(filepath.Join(p.Path, "binding.gyp"))
+		if m.Scripts["preinstall"] != "" || m.Scripts["install"] != "" || m.Scripts["postinstall"] != "" || gyp == nil {
+			heavy = append(heavy, p.Name)
+		}
+	}
+	return heavy
+}
+
+// restoreIssues lists what keeps a node_modules from being restored cheaply:
+// a missing lockfile, an unreachable registry or packages with install
+// scripts. No issues means it is cheap to restore.
+func restoreIssues(ctx context.Context, project, nodeModules string) []string {
+	var issues []string
+	if _, lockfile := findLockfile(project); lockfile == "" {
+		issues = append(issues, "no lockfile")
+	}
+	if registry := registryOf(project); !reachable(ctx, registry) {
+		issues = append(issues, "registry "+registry+" unreachable")
+	}
+	if heavy := installScripts(nodeModules); len(heavy) > 0 {
+		if len(heavy) > 3 {
+			heavy = append(heavy[:3], "...")
+		}
+		issues = append(issues, "install scripts in "+strings.Join(heavy, ", "))
+	}
+	return issues
+}
+
+// checkRestorable records for each node_modules of dirs what keeps it from
+// being restored cheaply, checking up to workers at a time. It reads every
+// package and asks the registries, so it is left to the outputs that show it.
+func checkRestorable(ctx context.Context, dirs []Directory, workers int) {
+	var (
+		wg        sync.WaitGroup
+		semaphore = make(chan struct{}, max(workers, 1))
+	)
+	for i := range dirs {
+		if dirs[i].kind != "node_modules" || dirs[i].global {
+			continue
+		}
+		wg.Add(1)
+		go func(dir *Directory) {
+			defer wg.Done()
+			semaphore <- struct{}{}        // Acquire
+			defer func() { <-semaphore }() // Release
+			if ctx.Err() != nil {
+				return
+			}
+			dir.issues, dir.checked = restoreIssues(ctx, dir.project, dir.path), true
+		}(&dirs[i])
+	}
+	wg.Wait()
+}
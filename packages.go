@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// packageSize is the disk footprint of one installed package
+type packageSize struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// packageDirs lists the package directories inside a node_modules
+// directory, expanding @scope folders and pnpm's virtual store
+func packageDirs(nodeModules string) ([]packageSize, error) {
+	entries, err := os.ReadDir(nodeModules)
+	if err != nil {
+		return nil, err
+	}
+
+	var pkgs []packageSize
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(nodeModules, name)
+		switch {
+		case !entry.IsDir() || name == ".bin" || name == ".cache":
+			continue
+		case name == ".pnpm":
+			// pnpm keeps the real files in .pnpm/<name>@<version>
+			store, _ := os.ReadDir(path)
+			for _, e := range store {
+				if e.IsDir() && e.Name() != "node_modules" {
+					pkgs = append(pkgs, packageSize{Name: strings.ReplaceAll(e.Name(), "+", "/"), Path: filepath.Join(path, e.Name())})
+				}
+			}
+		case strings.HasPrefix(name, "@"):
+			scoped, _ := os.ReadDir(path)
+			for _, e := range scoped {
+				if e.IsDir() {
+					pkgs = append(pkgs, packageSize{Name: name + "/" + e.Name(), Path: filepath.Join(path, e.Name())})
+				}
+			}
+		default:
+			pkgs = append(pkgs, packageSize{Name: name, Path: path})
+		}
+	}
+	return pkgs, nil
+}
+
+// runPackages lists the largest packages inside node_modules directories
+func runPackages(ctx context.Context, opts *options, args []string) error {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+
+	for _, arg := range args {
+		dir := expandHome(arg)
+		if filepath.Base(dir) != "node_modules" {
+			dir = filepath.Join(dir, "node_modules")
+		}
+		pkgs, err := packageDirs(dir)
+		if err != nil {
+			return fmt.Errorf("reading packages: %w", err)
+		}
+
+		var (
+			wg        sync.WaitGroup
+			semaphore = make(chan struct{}, max(opts.workers, 1))
+		)
+		for i := range pkgs {
+			wg.Add(1)
+			go func(p *packageSize) {
+				defer wg.Done()
+				semaphore <- struct{}{}        // Acquire
+				defer func() { <-semaphore }() // Release
+
+				p.Size, _, _ = calculateDirSize(ctx, p.Path, opts.diskUsage, nil)
+			}(&pkgs[i])
+		}
+		wg.Wait()
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Size > pkgs[j].Size })
+		var total int64
+		for _, p := range pkgs {
+			total += p.Size
+		}
+		count := len(pkgs)
+		if opts.top > 0 && len(pkgs) > opts.top {
+			pkgs = pkgs[:opts.top]
+		}
+
+		if opts.format == formatJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(pkgs); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Printf("%s (%d packages, %s)\n", dir, count, paint(os.Stdout, ansiBold, formatSize(total)))
+		for _, p := range pkgs {
+			size := fmt.Sprintf("%10s", formatSize(p.Size))
+			fmt.Printf("%s  %s\n", paintSize(os.Stdout, p.Size, size), p.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// notPinned keeps directories outside the pinned projects. Pinning a
+// workspace root covers the projects below it too.
+func notPinned(pinned []string) func(Directory) bool {
+	return func(dir Directory) bool {
+		if dir.global {
+			return true
+		}
+		for _, p := range pinned {
+			if isWithin(filepath.ToSlash(dir.project), filepath.ToSlash(filepath.Clean(expandHome(p)))) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// updateConfig applies edit to the YAML tree of the config file at path and
+// writes it back, keeping comments and the order of the other settings. A
+// missing file starts out empty.
+func updateConfig(path string, edit func(root *yaml.Node)) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("parsing %s: the top level is not a mapping", path)
+	}
+	edit(root)
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// sequenceOf returns the sequence stored under key in the mapping root,
+// adding an empty one if there is none
+func sequenceOf(root *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == key {
+			value := root.Content[i+1]
+			if value.Kind != yaml.SequenceNode {
+				*value = yaml.Node{Kind: yaml.SequenceNode}
+			}
+			return value
+		}
+	}
+	value := &yaml.Node{Kind: yaml.SequenceNode}
+	root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+	return value
+}
+
+// projectArgs resolves the projects named on the command line, accepting
+// their node_modules as well
+func projectArgs(args []string) ([]string, error) {
+	var projects []string
+	for _, arg := range args {
+		project, err := filepath.Abs(expandHome(arg))
+		if err != nil {
+			return nil, err
+		}
+		if filepath.Base(project) == "node_modules" {
+			project = filepath.Dir(project)
+		}
+		projects = append(projects, project)
+	}
+	return projects, nil
+}
+
+// runPin records projects as active in the config, which keeps their
+// directories out of every result. Without arguments it lists them.
+func runPin(_ context.Context, opts *options, args []string) error {
+	if len(args) == 0 {
+		if len(opts.pinned) == 0 {
+			fmt.Fprintln(opts.progress(), "No pinned projects.")
+			return errNothingFound
+		}
+		for _, p := range opts.pinned {
+			fmt.Println(p)
+		}
+		return nil
+	}
+
+	projects, err := projectArgs(args)
+	if err != nil {
+		return err
+	}
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	return updateConfig(path, func(root *yaml.Node) {
+		list := sequenceOf(root, "pinned")
+		for _, project := range projects {
+			if slices.ContainsFunc(list.Content, func(n *yaml.Node) bool { return filepath.Clean(expandHome(n.Value)) == project }) {
+				fmt.Printf("%s is already pinned\n", project)
+				continue
+			}
+			list.Content = append(list.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: project})
+			fmt.Printf("Pinned %s, its directories won't be offered for deletion\n", project)
+		}
+	})
+}
+
+// runUnpin drops projects from the pinned ones
+func runUnpin(_ context.Context, _ *options, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("unpin needs the projects to unpin")
+	}
+	projects, err := projectArgs(args)
+	if err != nil {
+		return err
+	}
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	return updateConfig(path, func(root *yaml.Node) {
+		list := sequenceOf(root, "pinned")
+		for _, project := range projects {
+			before := len(list.Content)
+			list.Content = slices.DeleteFunc(list.Content, func(n *yaml.Node) bool { return filepath.Clean(expandHome(n.Value)) == project })
+			if len(list.Content) == before {
+				fmt.Printf("%s wasn't pinned\n", project)
+				continue
+			}
+			fmt.Printf("Unpinned %s\n", project)
+		}
+	})
+}
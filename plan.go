@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// deletionPlan is what a clean run is about to delete and how, kept on disk
+// until the run finishes so resume can continue it after an interruption
+type deletionPlan struct {
+	Started    time.Time    `json:"started"`
+	Trash      bool         `json:"trash,omitempty"`
+	Archive    bool         `json:"archive,omitempty"`
+	ArchiveDir string       `json:"archive_dir,omitempty"`
+	Reinstall  bool         `json:"reinstall,omitempty"`
+	PrunePnpm  bool         `json:"prune_pnpm_store,omitempty"`
+	Dirs       []plannedDir `json:"dirs"`
+}
+
+// plannedDir is a directory of a plan, with what it was when found so resume
+// can tell it from whatever took its place since
+type plannedDir struct {
+	dirRecord
+	Identity *dirIdentity `json:"identity,omitempty"`
+}
+
+// dirIdentity tells a directory apart from another one later put at its
+// path. Where there are no inode numbers the mod time and size stand in.
+type dirIdentity struct {
+	Device  uint64    `json:"device,omitempty"`
+	Inode   uint64    `json:"inode,omitempty"`
+	ModTime time.Time `json:"mod_time"`
+	Size    int64     `json:"size"`
+}
+
+// identityOf returns the identity of the directory info describes
+func identityOf(info os.FileInfo) dirIdentity {
+	id := dirIdentity{ModTime: info.ModTime(), Size: info.Size()}
+	if key, _, ok := hardLinks(info); ok {
+		id.Device, id.Inode = key.dev, key.ino
+	}
+	return id
+}
+
+// matches reports whether info describes the directory id was taken of
+func (id dirIdentity) matches(info os.FileInfo) bool {
+	other := identityOf(info)
+	if id.Inode != 0 || other.Inode != 0 {
+		return id.Device == other.Device && id.Inode == other.Inode
+	}
+	return id.ModTime.Equal(other.ModTime) && id.Size == other.Size
+}
+
+// planPath returns where the plan of the current run is kept
+func planPath() (string, error) {
+	return statePath("plan.json")
+}
+
+// savePlan records that the selected directories are about to be deleted
+// with the settings in opts
+func savePlan(opts *options, selected []Directory) error {
+	path, err := planPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	plan := deletionPlan{
+		Started:    time.Now(),
+		Trash:      opts.trash,
+		Archive:    opts.archive,
+		ArchiveDir: opts.archiveDir,
+		Reinstall:  opts.reinstall,
+		PrunePnpm:  opts.prunePnpm,
+	}
+	for _, dir := range selected {
+		planned := plannedDir{dirRecord: newDirRecord(dir)}
+		if dir.stat != nil {
+			id := identityOf(dir.stat)
+			planned.Identity = &id
+		}
+		plan.Dirs = append(plan.Dirs, planned)
+	}
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write aside and rename, so a crash never leaves half a plan
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadPlan reads the plan of an unfinished run, or returns nil if there is
+// none
+func loadPlan() (*deletionPlan, error) {
+	path, err := planPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var plan deletionPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &plan, nil
+}
+
+// removePlan drops the plan once its run is over
+func removePlan() error {
+	path, err := planPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// directory converts a planned directory back into the Directory it was
+// made from
+func (p plannedDir) directory() Directory {
+	dir := p.dirRecord.directory()
+	dir.identity = p.Identity
+	return dir
+}
+
+// directory converts a record back into the Directory it was made from
+func (r dirRecord) directory() Directory {
+	dir := Directory{
+		path:      r.Path,
+		project:   r.Project,
+		kind:      r.Type,
+		manager:   r.Manager,
+		workspace: r.Workspace,
+		global:    r.Global,
+		modTime:   r.LastModified,
+	}
+	if r.Size != nil {
+		dir.size, dir.sized = *r.Size, true
+	}
+	if r.UniqueSize != nil {
+		dir.unique = *r.UniqueSize
+	}
+	return dir
+}
+
+// remaining returns what is left to delete of the plan. Directories that are
+// gone were deleted, except for leftovers renamed aside for removal; those
+// that changed after the run started were recreated and are kept.
+func (p *deletionPlan) remaining() []Directory {
+	var dirs []Directory
+	for _, record := range p.Dirs {
+		dir := record.directory()
+		info, err := os.Lstat(dir.path)
+		if err == nil {
+			if info.ModTime().After(p.Started) {
+				printWarning("skipping %s: it changed after the interrupted run started", dir.path)
+				continue
+			}
+			dirs = append(dirs, dir)
+			continue
+		}
+
+		staged, _ := filepath.Glob(filepath.Join(filepath.Dir(dir.path), "."+filepath.Base(dir.path)+stagedMarker+"*"))
+		for _, path := range staged {
+			info, err := os.Lstat(path)
+			if err != nil {
+				continue
+			}
+			// A rename keeps the inode, without one there is nothing to match
+			if id := dir.identity; id != nil && id.Inode != 0 && !id.matches(info) {
+				printWarning("skipping %s: it isn't what was left of %s", path, dir.path)
+				continue
+			}
+			leftover := dir
+			leftover.path, leftover.stat, leftover.identity = path, info, nil
+			dirs = append(dirs, leftover)
+		}
+	}
+	return dirs
+}
+
+// runResume continues the clean run that was interrupted before it deleted
+// everything it had selected
+func runResume(ctx context.Context, opts *options, _ []string) error {
+	if err := guardRoot(opts); err != nil {
+		return err
+	}
+	unlock, err := lockRun(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	progress := opts.progress()
+	plan, err := loadPlan()
+	if err != nil {
+		return fmt.Errorf("reading the deletion plan: %w", err)
+	}
+	if plan == nil {
+		fmt.Fprintln(progress, "No interrupted run to resume.")
+		return errNothingFound
+	}
+
+	dirs, err := guardProtected(plan.remaining(), opts)
+	if err != nil {
+		return err
+	}
+	if len(dirs) == 0 {
+		fmt.Fprintf(progress, "The run of %s has nothing left to delete.\n", plan.Started.Format("2006-01-02 15:04"))
+		return removePlan()
+	}
+	fmt.Fprintf(progress, "Resuming the run of %s: %d of %d directories are left\n", plan.Started.Format("2006-01-02 15:04"), len(dirs), len(plan.Dirs))
+
+	totalSize := totalSizeString(dirs)
+	if opts.dryRun {
+		printDryRun(os.Stdout, dirs, totalSize)
+		return nil
+	}
+	if !opts.yes {
+		confirm, err := askConfirm(fmt.Sprintf("Continue deleting %d directories (total size: %s)?", len(dirs), totalSize))
+		if err != nil {
+			return fmt.Errorf("during confirmation: %w", err)
+		}
+		if !confirm {
+			fmt.Fprintln(progress, "Operation cancelled, run resume again to continue later.")
+			return errAborted
+		}
+	}
+
+	opts.trash, opts.archive, opts.archiveDir = plan.Trash, plan.Archive, plan.ArchiveDir
+	opts.reinstall, opts.prunePnpm = plan.Reinstall, plan.PrunePnpm
+	_, err = carryOut(ctx, opts, dirs, progress)
+	return err
+}
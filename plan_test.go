@@ -0,0 +1,152 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDeletionPlanRemaining(t *testing.T) {
+	started := time.Now().Add(-time.Hour)
+	old := started.Add(-time.Hour)
+	// stage renames the planned directory aside as stageAndRemove does
+	stage := func(t *testing.T, dir plannedDir) string {
+		staged := filepath.Join(filepath.Dir(dir.Path), ".node_modules"+stagedMarker+"1")
+		if err := os.Rename(dir.Path, staged); err != nil {
+			t.Fatal(err)
+		}
+		return staged
+	}
+
+	tests := []struct {
+		name string
+		// setup changes the planned directory and returns the paths that
+		// are left to delete
+		setup func(t *testing.T, dir *plannedDir) []string
+	}{
+		{
+			name: "untouched",
+			setup: func(t *testing.T, dir *plannedDir) []string {
+				return []string{dir.Path}
+			},
+		},
+		{
+			name: "changed since the run started",
+			setup: func(t *testing.T, dir *plannedDir) []string {
+				if err := os.Chtimes(dir.Path, time.Now(), time.Now()); err != nil {
+					t.Fatal(err)
+				}
+				return nil
+			},
+		},
+		{
+			name: "deleted",
+			setup: func(t *testing.T, dir *plannedDir) []string {
+				if err := os.Remove(dir.Path); err != nil {
+					t.Fatal(err)
+				}
+				return nil
+			},
+		},
+		{
+			name: "interrupted after staging",
+			setup: func(t *testing.T, dir *plannedDir) []string {
+				return []string{stage(t, *dir)}
+			},
+		},
+		{
+			name: "staged without a recorded inode",
+			setup: func(t *testing.T, dir *plannedDir) []string {
+				dir.Identity.Device, dir.Identity.Inode = 0, 0
+				return []string{stage(t, *dir)}
+			},
+		},
+		{
+			name: "another tree in the place of the leftover",
+			setup: func(t *testing.T, dir *plannedDir) []string {
+				if dir.Identity.Inode == 0 {
+					t.Skip("no inode numbers to tell directories apart")
+				}
+				staged := stage(t, *dir)
+				if err := os.Rename(staged, filepath.Join(filepath.Dir(filepath.Dir(dir.Path)), "moved")); err != nil {
+					t.Fatal(err)
+				}
+				mkdirs(t, filepath.Dir(staged), filepath.Base(staged))
+				return nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			path := filepath.Join(root, "app", "node_modules")
+			mkdirs(t, root, filepath.Join("app", "node_modules"))
+			if err := os.Chtimes(path, old, old); err != nil {
+				t.Fatal(err)
+			}
+			id := identityOf(lstat(t, path))
+			dir := plannedDir{dirRecord: dirRecord{Path: path, Project: filepath.Dir(path)}, Identity: &id}
+			want := tt.setup(t, &dir)
+
+			plan := &deletionPlan{Started: started, Dirs: []plannedDir{dir}}
+			var got []string
+			for _, d := range plan.remaining() {
+				got = append(got, d.path)
+				if err := revalidate(d); err != nil {
+					t.Errorf("revalidate(%s) = %v", d.path, err)
+				}
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("remaining() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// fileInfo is an os.FileInfo without an inode, like on Windows
+type fileInfo struct {
+	modTime time.Time
+	size    int64
+}
+
+func (f fileInfo) Name() string       { return "node_modules" }
+func (f fileInfo) Size() int64        { return f.size }
+func (f fileInfo) Mode() os.FileMode  { return os.ModeDir | 0o755 }
+func (f fileInfo) ModTime() time.Time { return f.modTime }
+func (f fileInfo) IsDir() bool        { return true }
+func (f fileInfo) Sys() any           { return nil }
+
+func TestDirIdentityMatches(t *testing.T) {
+	root := t.TempDir()
+	mkdirs(t, root, "a", "b")
+	a, b := lstat(t, filepath.Join(root, "a")), lstat(t, filepath.Join(root, "b"))
+	found := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		id     dirIdentity
+		info   os.FileInfo
+		inodes bool // needs inode numbers
+		want   bool
+	}{
+		{name: "same directory", id: identityOf(a), info: a, inodes: true, want: true},
+		{name: "other directory", id: identityOf(a), info: b, inodes: true, want: false},
+		{name: "inode recorded, none now", id: identityOf(a), info: fileInfo{a.ModTime(), a.Size()}, inodes: true, want: false},
+		{name: "no inode recorded, one now", id: dirIdentity{ModTime: a.ModTime(), Size: a.Size()}, info: a, inodes: true, want: false},
+		{name: "same mod time and size", id: dirIdentity{ModTime: found, Size: 4096}, info: fileInfo{found, 4096}, want: true},
+		{name: "other mod time", id: dirIdentity{ModTime: found, Size: 4096}, info: fileInfo{found.Add(time.Second), 4096}, want: false},
+		{name: "other size", id: dirIdentity{ModTime: found, Size: 4096}, info: fileInfo{found, 8192}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, ok := hardLinks(a); tt.inodes && !ok {
+				t.Skip("no inode numbers here")
+			}
+			if got := tt.id.matches(tt.info); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// pnpmStorePath asks pnpm where its content-addressable store lives
+func pnpmStorePath(ctx context.Context) (string, error) {
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, "pnpm", "store", "path")
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("running pnpm store path: %w", err)
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// prunePnpmStore runs pnpm store prune, which drops store entries no
+// project references anymore, and reports how much it freed
+func prunePnpmStore(ctx context.Context, progress io.Writer) error {
+	if _, err := exec.LookPath("pnpm"); err != nil {
+		return fmt.Errorf("pnpm is not installed: %w", err)
+	}
+	store, err := pnpmStorePath(ctx)
+	if err != nil {
+		return err
+	}
+	before, _, _ := calculateDirSize(ctx, store, false, nil)
+
+	fmt.Fprintf(progress, "\nPruning the pnpm store at %s (%s) ⏳\n", store, formatSize(before))
+	cmd := exec.CommandContext(ctx, "pnpm", "store", "prune")
+	cmd.Stdout, cmd.Stderr = progress, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running pnpm store prune: %w", err)
+	}
+
+	after, _, _ := calculateDirSize(ctx, store, false, nil)
+	fmt.Fprintf(progress, "pnpm store pruned, %s freed ✅\n", formatSize(max(before-after, 0)))
+	return nil
+}
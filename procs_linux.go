@@ -0,0 +1,41 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// openPaths lists the files each process has open, plus its working
+// directory, by reading /proc. Processes of other users are skipped
+// silently, since their fds aren't readable.
+func openPaths() ([]procPath, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	var list []procPath
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		base := filepath.Join("/proc", entry.Name())
+		comm, _ := os.ReadFile(filepath.Join(base, "comm"))
+		proc := process{pid: pid, name: strings.TrimSpace(string(comm))}
+
+		if cwd, err := os.Readlink(filepath.Join(base, "cwd")); err == nil {
+			list = append(list, procPath{proc, cwd})
+		}
+		fds, _ := os.ReadDir(filepath.Join(base, "fd"))
+		for _, fd := range fds {
+			if target, err := os.Readlink(filepath.Join(base, "fd", fd.Name())); err == nil && filepath.IsAbs(target) {
+				list = append(list, procPath{proc, target})
+			}
+		}
+	}
+	return list, nil
+}
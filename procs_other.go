@@ -0,0 +1,49 @@
+//go:build !linux
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os/exec"
+	"strconv"
+)
+
+// openPaths lists the files each process has open, plus its working
+// directory, using lsof. Without lsof, as on Windows, it reports
+// errors.ErrUnsupported.
+func openPaths() ([]procPath, error) {
+	if _, err := exec.LookPath("lsof"); err != nil {
+		return nil, errors.ErrUnsupported
+	}
+	// -F emits one field per line: p<pid>, c<command>, n<name>
+	out, err := exec.Command("lsof", "-w", "-n", "-P", "-Fpcn").Output()
+	if err != nil && len(out) == 0 {
+		return nil, err
+	}
+
+	var (
+		list []procPath
+		proc process
+	)
+	lines := bufio.NewScanner(bytes.NewReader(out))
+	for lines.Scan() {
+		line := lines.Text()
+		if line == "" {
+			continue
+		}
+		switch line[0] {
+		case 'p':
+			pid, _ := strconv.Atoi(line[1:])
+			proc = process{pid: pid}
+		case 'c':
+			proc.name = line[1:]
+		case 'n':
+			if len(line) > 1 && line[1] == '/' {
+				list = append(list, procPath{proc, line[1:]})
+			}
+		}
+	}
+	return list, lines.Err()
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// builtinProfiles are the profiles available without any configuration. A
+// profile in the config file with the same name replaces the built-in one.
+var builtinProfiles = map[string]*fileConfig{
+	"js": {
+		Detectors: []string{"node_modules", "yarn-pnp", "yarn", "pnpm", "frontend-build", "monorepo-caches", "js-tool-caches"},
+	},
+	"python": {
+		Detectors: []string{"python"},
+	},
+	"rust": {
+		Detectors: []string{"rust"},
+	},
+	"java": {
+		Detectors: []string{"gradle", "maven"},
+	},
+	"android": {
+		Detectors:    []string{"android"},
+		GlobalCaches: true,
+	},
+	"elixir": {
+		Detectors: []string{"elixir"},
+	},
+	"flutter": {
+		Detectors: []string{"flutter"},
+	},
+	"ios": {
+		Detectors:    []string{"cocoapods", "xcode"},
+		GlobalCaches: true,
+	},
+	"php": {
+		Detectors: []string{"composer"},
+	},
+	"ruby": {
+		Detectors: []string{"ruby"},
+	},
+	"unity": {
+		Detectors: []string{"unity"},
+	},
+	"zig": {
+		Detectors: []string{"zig"},
+	},
+	"monorepo": {
+		Detectors: []string{"monorepo-caches"},
+	},
+	"test-artifacts": {
+		Detectors: []string{"test-artifacts"},
+	},
+	"terraform": {
+		Detectors: []string{"terraform"},
+	},
+	"everything": {
+		Detectors: []string{"node_modules", "yarn-pnp", "yarn", "pnpm", "rust", "python", "gradle", "maven", "terraform", "cocoapods", "xcode", "composer", "ruby", "frontend-build", "elixir", "monorepo-caches", "js-tool-caches", "test-artifacts", "unity", "flutter", "android", "zig"},
+		// Broad patterns, so stay away from current work by default
+		KeepRecent: 3,
+		OlderThan:  "30d",
+	},
+}
+
+// profileNames lists the built-in and configured profiles
+func (c *fileConfig) profileNames() []string {
+	var names []string
+	for name := range builtinProfiles {
+		names = append(names, name)
+	}
+	for name := range c.Profiles {
+		if _, ok := builtinProfiles[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyProfile copies the defaults of the named profile, or of the config's
+// default profile when name is empty, into opts
+func (c *fileConfig) applyProfile(name string, opts *options) error {
+	if name == "" {
+		name = c.Profile
+	}
+	if name == "" {
+		return nil
+	}
+
+	profile, ok := c.Profiles[name]
+	if !ok {
+		profile, ok = builtinProfiles[name]
+	}
+	if !ok {
+		return fmt.Errorf("unknown profile %q (available: %s)", name, strings.Join(c.profileNames(), ", "))
+	}
+	if err := profile.apply(opts); err != nil {
+		return fmt.Errorf("profile %s: %w", name, err)
+	}
+	opts.profile = name
+	return nil
+}
+
+// profileFromArgs returns the --profile given in args. It parses args with a
+// throwaway flag set so the profile can be applied before the real parse,
+// which reports any errors.
+func profileFromArgs(cmd *command, args []string) string {
+	var scratch options
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	cmd.flags(fs, &scratch)
+	fs.Parse(args)
+	return scratch.profile
+}
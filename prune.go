@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// manifest holds the dependency lists of a package.json
+type manifest struct {
+	Name                 string            `json:"name"`
+	Version              string            `json:"version"`
+	Dependencies         map[string]string `json:"dependencies"`
+	OptionalDependencies map[string]string `json:"optionalDependencies"`
+	PeerDependencies     map[string]string `json:"peerDependencies"`
+	DevDependencies      map[string]string `json:"devDependencies"`
+}
+
+// runtimeDeps returns the names a package needs when it runs
+func (m *manifest) runtimeDeps() []string {
+	var names []string
+	for _, deps := range []map[string]string{m.Dependencies, m.OptionalDependencies, m.PeerDependencies} {
+		for name := range deps {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// readManifest parses the package.json in dir
+func readManifest(dir string) (*manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", filepath.Join(dir, "package.json"), err)
+	}
+	return m, nil
+}
+
+// installTree is the package tree installed below a project, keyed by
+// package directory
+type installTree struct {
+	project  string
+	packages map[string]*manifest
+}
+
+// readInstallTree reads every package installed in the node_modules of
+// project, including ones nested in other packages' node_modules
+func readInstallTree(project string) *installTree {
+	tree := &installTree{project: project, packages: make(map[string]*manifest)}
+	var walk func(nodeModules string)
+	walk = func(nodeModules string) {
+		pkgs, err := packageDirs(nodeModules)
+		if err != nil {
+			return
+		}
+		for _, p := range pkgs {
+			m, err := readManifest(p.Path)
+			if err != nil {
+				continue
+			}
+			tree.packages[p.Path] = m
+			walk(filepath.Join(p.Path, "node_modules"))
+		}
+	}
+	walk(filepath.Join(project, "node_modules"))
+	return tree
+}
+
+// resolve finds the package that require(name) loads from dir, walking up
+// through the enclosing node_modules directories like Node does
+func (t *installTree) resolve(dir, name string) string {
+	for {
+		candidate := filepath.Join(dir, "node_modules", name)
+		if _, ok := t.packages[candidate]; ok {
+			return candidate
+		}
+		if dir == t.project {
+			return ""
+		}
+		dir = t.owner(dir)
+	}
+}
+
+// owner returns the package or project whose node_modules holds dir
+func (t *installTree) owner(dir string) string {
+	for dir != t.project {
+		parent := filepath.Dir(dir)
+		if filepath.Base(parent) == "node_modules" {
+			return filepath.Dir(parent)
+		}
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return t.project
+}
+
+// reachable returns the installed packages needed by names, required from
+// the project root, and everything they need in turn
+func (t *installTree) reachable(names []string) map[string]bool {
+	seen := make(map[string]bool)
+	type want struct{ from, name string }
+	queue := make([]want, 0, len(names))
+	for _, name := range names {
+		queue = append(queue, want{t.project, name})
+	}
+	for len(queue) > 0 {
+		w := queue[0]
+		queue = queue[1:]
+		path := t.resolve(w.from, w.name)
+		if path == "" || seen[path] {
+			continue
+		}
+		seen[path] = true
+		for _, dep := range t.packages[path].runtimeDeps() {
+			queue = append(queue, want{path, dep})
+		}
+	}
+	return seen
+}
+
+// devOnlyPackages returns the installed packages that only devDependencies
+// need. Packages also reachable from dependencies are kept.
+func devOnlyPackages(project string) ([]string, error) {
+	root, err := readManifest(project)
+	if err != nil {
+		return nil, err
+	}
+	tree := readInstallTree(project)
+	prod := tree.reachable(root.runtimeDeps())
+
+	var dev []string
+	for name := range root.DevDependencies {
+		dev = append(dev, name)
+	}
+	var remove []string
+	for path := range tree.reachable(dev) {
+		if !prod[path] {
+			remove = append(remove, path)
+		}
+	}
+	return remove, nil
+}
+
+// npmLockPaths returns the install paths, relative to the project and
+// slash-separated, that a package-lock.json or npm-shrinkwrap.json pins
+func npmLockPaths(lockfile string) (map[string]bool, error) {
+	data, err := os.ReadFile(lockfile)
+	if err != nil {
+		return nil, err
+	}
+	type v1Dep struct {
+		Dependencies map[string]json.RawMessage `json:"dependencies"`
+	}
+	var lock struct {
+		Packages     map[string]json.RawMessage `json:"packages"`     // lockfileVersion 2 and 3
+		Dependencies map[string]json.RawMessage `json:"dependencies"` // lockfileVersion 1
+	}
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", lockfile, err)
+	}
+
+	paths := make(map[string]bool)
+	if lock.Packages != nil {
+		for key := range lock.Packages {
+			paths[key] = true
+		}
+		return paths, nil
+	}
+	// Version 1 nests packages the way they are installed
+	var walk func(prefix string, deps map[string]json.RawMessage)
+	walk = func(prefix string, deps map[string]json.RawMessage) {
+		for name, raw := range deps {
+			path := prefix + "node_modules/" + name
+			paths[path] = true
+			var dep v1Dep
+			if json.Unmarshal(raw, &dep) == nil {
+				walk(path+"/", dep.Dependencies)
+			}
+		}
+	}
+	walk("", lock.Dependencies)
+	return paths, nil
+}
+
+// yarnLockVersions returns the name@version pairs a yarn.lock resolves to,
+// for both classic and Berry lockfiles
+func yarnLockVersions(lockfile string) (map[string]bool, error) {
+	f, err := os.Open(lockfile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	versions := make(map[string]bool)
+	var names []string
+	lines := bufio.NewScanner(f)
+	for lines.Scan() {
+		line := lines.Text()
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case !strings.HasPrefix(line, " "):
+			// `"@babel/core@^7.0.0", "@babel/core@npm:^7.1.0":`
+			names = names[:0]
+			for _, spec := range strings.Split(strings.TrimSuffix(line, ":"), ",") {
+				spec = strings.Trim(strings.TrimSpace(spec), `"`)
+				if i := strings.Index(spec[min(1, len(spec)):], "@"); i >= 0 {
+					names = append(names, spec[:i+1])
+				}
+			}
+		case strings.HasPrefix(line, "  version"):
+			version := strings.TrimLeft(strings.TrimPrefix(line, "  version"), ": ")
+			version = strings.Trim(version, `"`)
+			for _, name := range names {
+				versions[name+"@"+version] = true
+			}
+		}
+	}
+	return versions, lines.Err()
+}
+
+// extraneousPackages returns the installed packages the project's lockfile
+// doesn't pin, which is what npm prune removes
+func extraneousPackages(project string) ([]string, error) {
+	manager, lockfile := findLockfile(project)
+	tree := readInstallTree(project)
+
+	var pinned func(path string, m *manifest) bool
+	switch manager {
+	case "npm":
+		paths, err := npmLockPaths(lockfile)
+		if err != nil {
+			return nil, err
+		}
+		pinned = func(path string, _ *manifest) bool {
+			// Keys are relative to the lockfile, the workspace root for members
+			rel, err := filepath.Rel(filepath.Dir(lockfile), path)
+			return err != nil || paths[filepath.ToSlash(rel)]
+		}
+	case "yarn":
+		versions, err := yarnLockVersions(lockfile)
+		if err != nil {
+			return nil, err
+		}
+		pinned = func(_ string, m *manifest) bool {
+			return versions[m.Name+"@"+m.Version]
+		}
+	case "":
+		return nil, fmt.Errorf("no lockfile to compare node_modules against")
+	default:
+		return nil, fmt.Errorf("comparing against %s lockfiles isn't supported", filepath.Base(lockfile))
+	}
+
+	var remove []string
+	for path, m := range tree.packages {
+		if !pinned(path, m) {
+			remove = append(remove, path)
+		}
+	}
+	return remove, nil
+}
+
+// outermost drops duplicates and the paths that lie inside another path of
+// the list, since deleting the outer one removes them too
+func outermost(paths []string) []string {
+	sort.Strings(paths)
+	var kept []string
+	for _, p := range paths {
+		if n := len(kept); n > 0 && (p == kept[n-1] || strings.HasPrefix(p, kept[n-1]+string(filepath.Separator))) {
+			continue
+		}
+		kept = append(kept, p)
+	}
+	return kept
+}
+
+// removeDanglingBins deletes the links in node_modules/.bin whose package
+// was pruned
+func removeDanglingBins(nodeModules string) {
+	bin := filepath.Join(nodeModules, ".bin")
+	entries, _ := os.ReadDir(bin)
+	for _, entry := range entries {
+		path := filepath.Join(bin, entry.Name())
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			os.Remove(path)
+		}
+	}
+}
+
+// runPrune removes part of the node_modules of each project instead of all
+// of it
+func runPrune(ctx context.Context, opts *options, args []string) error {
+	if !opts.pruneDev && !opts.pruneExtraneous {
+		return fmt.Errorf("prune needs --dev, --extraneous or both")
+	}
+	if err := guardRoot(opts); err != nil {
+		return err
+	}
+	unlock, err := lockRun(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	progress := opts.progress()
+
+	var dirs []Directory
+	for _, arg := range args {
+		project, err := filepath.Abs(expandHome(arg))
+		if err != nil {
+			return err
+		}
+		if filepath.Base(project) == "node_modules" {
+			project = filepath.Dir(project)
+		}
+		if manager := detectPackageManager(project); manager == "pnpm" {
+			printWarning("skipping %s: pnpm links packages from its store, use pnpm prune --prod there", project)
+			continue
+		}
+
+		var paths []string
+		if opts.pruneDev {
+			dev, err := devOnlyPackages(project)
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", project, err)
+			}
+			paths = append(paths, dev...)
+		}
+		if opts.pruneExtraneous {
+			extra, err := extraneousPackages(project)
+			if err != nil {
+				printWarning("not looking for extraneous packages in %s: %v", project, err)
+			}
+			paths = append(paths, extra...)
+		}
+		for _, path := range outermost(paths) {
+			dir := Directory{path: path, project: project}
+			dir.stat, _ = os.Lstat(path)
+			dir.size, dir.unique, _ = calculateDirSize(ctx, path, opts.diskUsage, nil)
+			dir.sized = true
+			dirs = append(dirs, dir)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	dirs, err = guardProtected(dirs, opts)
+	if err != nil {
+		return err
+	}
+	if len(dirs) == 0 {
+		fmt.Fprintln(progress, "Nothing to prune.")
+		return errNothingFound
+	}
+
+	totalSize := totalSizeString(dirs)
+	if opts.dryRun {
+		printDryRun(os.Stdout, dirs, totalSize)
+		return nil
+	}
+	if !opts.yes {
+		confirm, err := askConfirm(fmt.Sprintf("Remove %d packages (total size: %s)?", len(dirs), totalSize))
+		if err != nil {
+			return fmt.Errorf("during confirmation: %w", err)
+		}
+		if !confirm {
+			fmt.Fprintln(progress, "Operation cancelled.")
+			return errAborted
+		}
+	}
+
+	audit, err := openAudit(opts)
+	if err != nil {
+		printWarning("audit log disabled: %v", err)
+	}
+	defer audit.Close()
+	dirs, denied := checkPermissions(dirs)
+	summary := deleteDirectories(ctx, dirs, opts.workers, opts.trash, progress, audit)
+	for _, arg := range args {
+		project, _ := filepath.Abs(expandHome(arg))
+		if filepath.Base(project) != "node_modules" {
+			project = filepath.Join(project, "node_modules")
+		}
+		removeDanglingBins(project)
+	}
+	if err := summary.interrupted(); err != nil {
+		return err
+	}
+	failed := summary.failed + denied
+	fmt.Printf("\nPruned %d packages (%s freed), %d failed\n", summary.deleted, formatSize(summary.freed), failed)
+	if failed > 0 {
+		return &codeError{
+			code: exitPartialFailure,
+			err:  fmt.Errorf("%d of %d packages could not be removed", failed, len(dirs)+denied),
+		}
+	}
+	return nil
+}
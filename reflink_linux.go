@@ -0,0 +1,99 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// ficlone is the ioctl that shares the extents of one file with another
+const ficlone = 0x40049409
+
+// reflink creates dst as a copy-on-write clone of src
+func reflink(src, dst string, perm os.FileMode) error {
+	s, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	d, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, d.Fd(), ficlone, s.Fd()); errno != 0 {
+		d.Close()
+		os.Remove(dst)
+		return errno
+	}
+	return d.Close()
+}
+
+const (
+	// fsIocFiemap is the ioctl that maps the extents of a file
+	fsIocFiemap = 0xc020660b
+	// fiemapFlagSync flushes pending writes so every extent has a place
+	fiemapFlagSync = 0x1
+
+	fiemapExtentLast = 0x1
+	// Extents without a known place, or stored inside the metadata, can't
+	// be told apart from copies
+	fiemapExtentUncertain = 0x2 | 0x4 | 0x8 | 0x200
+	fiemapExtentShared    = 0x2000
+
+	fiemapExtents = 64
+)
+
+type fiemapExtent struct {
+	logical, physical, length uint64
+	_                         [2]uint64
+	flags                     uint32
+	_                         [3]uint32
+}
+
+type fiemap struct {
+	start, length           uint64
+	flags, mapped, count, _ uint32
+	extents                 [fiemapExtents]fiemapExtent
+}
+
+// extents returns the extent map of path, or false when it can't be read
+// or is too fragmented to compare
+func extents(path string) ([]fiemapExtent, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	m := fiemap{length: ^uint64(0), flags: fiemapFlagSync, count: fiemapExtents}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), fsIocFiemap, uintptr(unsafe.Pointer(&m))); errno != 0 {
+		return nil, false
+	}
+	if m.mapped == 0 || m.extents[m.mapped-1].flags&fiemapExtentLast == 0 {
+		return nil, false
+	}
+	return m.extents[:m.mapped], true
+}
+
+// cloned reports whether src and dst are copy-on-write clones sharing all
+// of their data, like the ones reflink creates
+func cloned(src, dst string) bool {
+	a, ok := extents(src)
+	if !ok {
+		return false
+	}
+	b, ok := extents(dst)
+	if !ok || len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].flags&fiemapExtentShared == 0 || a[i].flags&fiemapExtentUncertain != 0 ||
+			a[i].logical != b[i].logical || a[i].physical != b[i].physical || a[i].length != b[i].length {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// reflink isn't available here, so dedupe --reflink reports it unsupported
+func reflink(src, dst string, perm os.FileMode) error {
+	return errors.ErrUnsupported
+}
+
+// cloned can't tell clones apart here, so they are compared like copies
+func cloned(src, dst string) bool {
+	return false
+}
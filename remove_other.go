@@ -0,0 +1,12 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// removeAll deletes the directory path and its contents. os.RemoveAll opens
+// every level without following symlinks and unlinks the ones it finds, so
+// link targets outside path are never touched.
+func removeAll(path string) error {
+	return os.RemoveAll(path)
+}
@@ -0,0 +1,44 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// removeAll deletes the directory path and its contents. Junctions and
+// symlinks below it are removed as links, never descended into, which
+// os.RemoveAll doesn't guarantee for every kind of reparse point here.
+func removeAll(path string) error {
+	entries, err := os.ReadDir(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	var first error
+	for _, entry := range entries {
+		p := filepath.Join(path, entry.Name())
+		info, err := entry.Info()
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			continue
+		case err != nil:
+		case info.Mode().Type() == fs.ModeDir:
+			err = removeAll(p)
+		default:
+			err = os.Remove(p)
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) && first == nil {
+			first = err
+		}
+	}
+	if first != nil {
+		return first
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
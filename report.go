@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// resultRecord is the machine-readable outcome of deleting one directory
+type resultRecord struct {
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+// runReport collects what happened during a clean run for --report
+type runReport struct {
+	Started        time.Time      `json:"started"`
+	Roots          string         `json:"roots"`
+	DryRun         bool           `json:"dry_run"`
+	Outcome        string         `json:"outcome"`
+	ScanDuration   string         `json:"scan_duration"`
+	DeleteDuration string         `json:"delete_duration,omitempty"`
+	Found          []dirRecord    `json:"found"`
+	Selected       []dirRecord    `json:"selected"`
+	Deleted        []resultRecord `json:"deleted"`
+	Failed         []resultRecord `json:"failed"`
+	TotalFreed     int64          `json:"total_freed"`
+}
+
+// newRunReport starts a report for a run beginning now
+func newRunReport(dryRun bool) *runReport {
+	return &runReport{
+		Started:  time.Now(),
+		DryRun:   dryRun,
+		Found:    []dirRecord{},
+		Selected: []dirRecord{},
+		Deleted:  []resultRecord{},
+		Failed:   []resultRecord{},
+	}
+}
+
+// addDeletions records the outcome of a deletion run
+func (r *runReport) addDeletions(summary deleteSummary) {
+	r.DeleteDuration = summary.duration.Round(time.Millisecond).String()
+	r.TotalFreed = summary.freed
+	for _, res := range summary.results {
+		record := resultRecord{
+			Path:     res.dir.path,
+			Size:     res.dir.size,
+			Duration: res.duration.Round(time.Millisecond).String(),
+		}
+		if res.err != nil {
+			record.Error = res.err.Error()
+			r.Failed = append(r.Failed, record)
+		} else {
+			r.Deleted = append(r.Deleted, record)
+		}
+	}
+}
+
+// finish records how the run ended based on the command's error
+func (r *runReport) finish(err error) {
+	switch {
+	case err == nil && r.DryRun:
+		r.Outcome = "dry run"
+	case err == nil:
+		r.Outcome = "completed"
+	default:
+		switch exitCodeOf(err) {
+		case exitNothingFound:
+			r.Outcome = "nothing found"
+		case exitAborted:
+			r.Outcome = "aborted"
+		case exitPartialFailure:
+			r.Outcome = "partial failure"
+		default:
+			r.Outcome = "error: " + err.Error()
+		}
+	}
+}
+
+// write saves the report to path, as JSON for a .json extension and as
+// plain text otherwise
+func (r *runReport) write(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(r)
+	} else {
+		err = r.writeText(f)
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// writeText writes a human-readable version of the report
+func (r *runReport) writeText(w io.Writer) error {
+	recordsSize := func(records []dirRecord) string {
+		var total int64
+		for _, rec := range records {
+			if rec.Size != nil {
+				total += *rec.Size
+			}
+		}
+		return formatSize(total)
+	}
+
+	fmt.Fprintf(w, "clean-modules report (started %s)\n\n", r.Started.Format(time.RFC3339))
+	fmt.Fprintf(w, "Roots:             %s\n", r.Roots)
+	fmt.Fprintf(w, "Outcome:           %s\n", r.Outcome)
+	fmt.Fprintf(w, "Dry run:           %t\n", r.DryRun)
+	fmt.Fprintf(w, "Scan duration:     %s\n", r.ScanDuration)
+	if r.DeleteDuration != "" {
+		fmt.Fprintf(w, "Deletion duration: %s\n", r.DeleteDuration)
+	}
+	fmt.Fprintf(w, "Found:             %d (%s)\n", len(r.Found), recordsSize(r.Found))
+	fmt.Fprintf(w, "Selected:          %d (%s)\n", len(r.Selected), recordsSize(r.Selected))
+	fmt.Fprintf(w, "Deleted:           %d\n", len(r.Deleted))
+	fmt.Fprintf(w, "Failed:            %d\n", len(r.Failed))
+	fmt.Fprintf(w, "Total freed:       %s\n", formatSize(r.TotalFreed))
+
+	sections := []struct {
+		title   string
+		records []dirRecord
+	}{{"Found", r.Found}, {"Selected", r.Selected}}
+	for _, section := range sections {
+		if len(section.records) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s:\n", section.title)
+		for _, rec := range section.records {
+			size := "?"
+			if rec.Size != nil {
+				size = formatSize(*rec.Size)
+			}
+			fmt.Fprintf(w, "  %s (%s)\n", rec.Path, size)
+		}
+	}
+
+	if len(r.Deleted) > 0 {
+		fmt.Fprintf(w, "\nDeleted:\n")
+		for _, rec := range r.Deleted {
+			fmt.Fprintf(w, "  %s (%s) in %s\n", rec.Path, formatSize(rec.Size), rec.Duration)
+		}
+	}
+	if len(r.Failed) > 0 {
+		fmt.Fprintf(w, "\nFailed:\n")
+		for _, rec := range r.Failed {
+			fmt.Fprintf(w, "  %s: %s\n", rec.Path, rec.Error)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !unix && !windows
+
+package main
+
+// isPrivileged reports false where there is no notion of a superuser here
+func isPrivileged() bool {
+	return false
+}
@@ -0,0 +1,10 @@
+//go:build unix
+
+package main
+
+import "os"
+
+// isPrivileged reports whether the process runs as root
+func isPrivileged() bool {
+	return os.Geteuid() == 0
+}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// tokenElevation is the TOKEN_INFORMATION_CLASS value for TOKEN_ELEVATION
+const tokenElevation = 20
+
+// isPrivileged reports whether the process runs elevated as Administrator
+func isPrivileged() bool {
+	process, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return false
+	}
+	var token syscall.Token
+	if err := syscall.OpenProcessToken(process, syscall.TOKEN_QUERY, &token); err != nil {
+		return false
+	}
+	defer token.Close()
+
+	var elevated, n uint32
+	if err := syscall.GetTokenInformation(token, tokenElevation, (*byte)(unsafe.Pointer(&elevated)), uint32(unsafe.Sizeof(elevated)), &n); err != nil {
+		return false
+	}
+	return elevated != 0
+}
@@ -0,0 +1,752 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Directory represents a target directory, node_modules by default, with its size
+type Directory struct {
+	path       string
+	size       int64        // apparent size, hard-linked files counted once
+	unique     int64        // bytes only this directory holds, i.e. freed by deleting it
+	project    string       // directory containing the target
+	kind       string       // detector that recognized it, "" for --name matches
+	manager    string       // JavaScript package manager of the project, if known
+	workspace  string       // root of the monorepo workspace the project belongs to
+	archive    string       // archive made before deleting it with --archive
+	archiveSum string       // hex SHA-256 of the archive
+	risk       string       // overrides the detector's risk, e.g. for stale installs
+	issues     []string     // why restoring a node_modules isn't cheap, if checked
+	checked    bool         // whether issues were determined
+	tracked    int          // files below it that git tracks
+	dirty      bool         // whether tracked files have uncommitted changes
+	synced     string       // cloud storage service syncing it, if any
+	stat       os.FileInfo  // as seen when it was found, to notice it being replaced
+	identity   *dirIdentity // recorded in a deletion plan instead of stat
+	global     bool         // machine-wide cache rather than part of a project
+	notes      []string     // detector remarks, e.g. how cheap restoring is
+	modTime    time.Time    // newest modification time among the project's files
+	sized      bool         // false when sizing was skipped with --no-size
+}
+
+// sizeString formats the directory size, or "?" when it wasn't computed
+func (d Directory) sizeString() string {
+	if !d.sized {
+		return "?"
+	}
+	return formatSize(d.size)
+}
+
+// detector returns the detector that recognized the directory, or nil
+func (d Directory) detector() *detector {
+	if d.kind == "" {
+		return nil
+	}
+	det, _ := findDetector(d.kind)
+	return det
+}
+
+// riskLevel returns how much could be lost by deleting the directory
+func (d Directory) riskLevel() string {
+	if d.risk != "" {
+		return d.risk
+	}
+	if det := d.detector(); det != nil {
+		return det.risk
+	}
+	return ""
+}
+
+// restorability returns a ✅ when the directory can be restored cheaply and
+// a ⚠️ with the reasons otherwise, or "" when that wasn't checked
+func (d Directory) restorability() string {
+	switch {
+	case !d.checked:
+		return ""
+	case len(d.issues) == 0:
+		return "✅"
+	}
+	return "⚠️  " + strings.Join(d.issues, ", ")
+}
+
+// restoreHint returns the command that regenerates the directory, if known
+func (d Directory) restoreHint() string {
+	if d.kind == "node_modules" && d.manager != "" {
+		if args := installCommand(d.project); args != nil {
+			return strings.Join(args, " ")
+		}
+		return d.manager + " install"
+	}
+	if det := d.detector(); det != nil {
+		return det.restore
+	}
+	return ""
+}
+
+// scanOptions controls which directories findNodeModules visits and reports
+type scanOptions struct {
+	detectors []*detector   // selected detectors, also used for global caches
+	targets   targetMatcher // directory names to report
+	excludes  globList
+	includes  globList // when non-empty, only matching subtrees are reported
+	maxDepth  int      // maximum directory depth below root, 0 for unlimited
+	workers   int      // maximum number of directories read or sized concurrently
+
+	followSymlinks bool // traverse symlinked directories (never symlinked node_modules)
+	oneFileSystem  bool // don't descend into directories on other filesystems
+	skipHidden     bool // don't descend into dot-directories below the root
+	allowOrphans   bool // report node_modules without a package.json beside them
+	skipSize       bool // don't compute directory sizes
+	diskUsage      bool // measure allocated disk space instead of apparent size
+
+	errors *walkErrors // paths that couldn't be read
+}
+
+// orphaned reports whether path is a node_modules directory without a
+// package.json in its parent, so probably not an install of a project
+func orphaned(path string) bool {
+	if filepath.Base(path) != "node_modules" {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(filepath.Dir(path), "package.json"))
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// sizeTally adds up the size of a tree as it is walked, possibly by several
+// walkers at once. With usage set it sums allocated blocks, directories
+// included, instead of file sizes.
+//
+// Hard-linked files, as pnpm creates into its store, count once. The unique
+// part is what deleting the tree would actually free: files whose every link
+// lies inside it.
+type sizeTally struct {
+	mu     sync.Mutex
+	usage  bool
+	size   int64
+	unique int64
+	inodes map[inodeKey]*linkedFile
+}
+
+// linkedFile is a hard-linked file met while tallying
+type linkedFile struct {
+	size        int64
+	seen, links uint64
+}
+
+func newSizeTally(usage bool) *sizeTally {
+	return &sizeTally{usage: usage, inodes: make(map[inodeKey]*linkedFile)}
+}
+
+// counts reports whether an entry of the given type adds to the total, so
+// its info is worth an lstat. Directories have no size of their own unless
+// blocks are counted.
+func (t *sizeTally) counts(d fs.DirEntry) bool {
+	return t.usage || !d.IsDir()
+}
+
+// add counts one entry of the tree
+func (t *sizeTally) add(info os.FileInfo) {
+	var n int64
+	switch {
+	case t.usage:
+		n = diskUsage(info)
+	case !info.IsDir():
+		n = info.Size()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key, links, ok := hardLinks(info)
+	if !ok || links <= 1 || info.IsDir() {
+		t.size += n
+		t.unique += n
+		return
+	}
+	if l, seen := t.inodes[key]; seen {
+		l.seen++
+		return
+	}
+	t.inodes[key] = &linkedFile{size: n, seen: 1, links: links}
+	t.size += n
+}
+
+// totals returns the size of everything added, and the part of it unique to
+// the tree
+func (t *sizeTally) totals() (size, unique int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	unique = t.unique
+	for _, l := range t.inodes {
+		if l.seen >= l.links {
+			unique += l.size
+		}
+	}
+	return t.size, unique
+}
+
+// calculateDirSize calculates the total size of a directory as a sizeTally
+// does, giving up when ctx is done. Unreadable entries below path are
+// recorded in errs and left out of the total.
+func calculateDirSize(ctx context.Context, path string, usage bool, errs *walkErrors) (size, unique int64, err error) {
+	tally := newSizeTally(usage)
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil {
+			if p == path {
+				return err
+			}
+			errs.add(p, err)
+			return nil
+		}
+		if !tally.counts(d) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			errs.add(p, err)
+			return nil
+		}
+		tally.add(info)
+		return nil
+	})
+	size, unique = tally.totals()
+	return size, unique, err
+}
+
+// projectModTime returns the newest modification time among the entries of
+// project, ignoring the target directory itself. A project with no other
+// entries falls back to the modification time of the project directory.
+func projectModTime(project, skip string) time.Time {
+	var newest time.Time
+	entries, _ := os.ReadDir(project)
+	for _, entry := range entries {
+		if filepath.Join(project, entry.Name()) == skip {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+
+	if newest.IsZero() {
+		if info, err := os.Stat(project); err == nil {
+			newest = info.ModTime()
+		}
+	}
+	return newest
+}
+
+// describeDirectory records the project details of path, leaving its size
+// to the caller
+func describeDirectory(ctx context.Context, path, project, kind string) Directory {
+	// For nested names like .yarn/cache, ignore the project entry holding it
+	skip := path
+	for filepath.Dir(skip) != project && filepath.Dir(skip) != skip {
+		skip = filepath.Dir(skip)
+	}
+	dir := Directory{
+		path:    path,
+		project: project,
+		kind:    kind,
+		modTime: projectModTime(project, skip),
+	}
+	dir.stat, _ = os.Lstat(path)
+	if d, err := findDetector(kind); kind != "" && err == nil {
+		dir.notes = d.notesFor(project)
+	}
+	if isStaged(filepath.Base(path)) {
+		dir.notes = append(dir.notes, "left over from an interrupted deletion")
+	}
+	if kind == "node_modules" {
+		dir.manager = detectPackageManager(project)
+		dir.workspace = workspaceRoot(project)
+		var note string
+		if dir.risk, note = installRisk(project, path); note != "" {
+			dir.notes = append(dir.notes, note)
+		}
+	}
+	// Legacy projects sometimes commit parts of what looks regenerable
+	if dir.tracked, dir.dirty = gitState(ctx, path); dir.tracked > 0 {
+		dir.risk = riskHigh
+		dir.notes = append(dir.notes, gitNote(dir.tracked, dir.dirty))
+	}
+	if dir.synced = syncService(path); dir.synced != "" {
+		dir.notes = append(dir.notes, "⚠️  in a "+dir.synced+" folder, deleting it syncs to other devices")
+	}
+	return dir
+}
+
+// newDirectory records the project details of path and, unless sizing is
+// skipped, its size
+func newDirectory(ctx context.Context, path, project, kind string, scan scanOptions) (Directory, error) {
+	dir := describeDirectory(ctx, path, project, kind)
+	if scan.skipSize {
+		return dir, nil
+	}
+
+	size, unique, err := calculateDirSize(ctx, path, scan.diskUsage, scan.errors)
+	if err != nil {
+		return Directory{}, err
+	}
+	dir.size, dir.unique, dir.sized = size, unique, true
+	return dir, nil
+}
+
+// readDirectories sizes the directories listed one per line in r, skipping
+// excluded paths and anything that isn't a directory. Directories not sized
+// before ctx is done are left out.
+func readDirectories(ctx context.Context, r io.Reader, scan scanOptions) ([]Directory, error) {
+	var paths []string
+	lines := bufio.NewScanner(r)
+	for lines.Scan() {
+		path := strings.TrimSpace(lines.Text())
+		if path == "" {
+			continue
+		}
+		path = expandHome(path)
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		if scan.excludes.match(path) {
+			continue
+		}
+		if manager := insideToolchain(path); manager != "" {
+			fmt.Fprintf(os.Stderr, "Skipping %s: it belongs to a node installation managed by %s\n", path, manager)
+			continue
+		}
+
+		info, err := os.Lstat(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", path, err)
+			continue
+		}
+		if !info.IsDir() {
+			fmt.Fprintf(os.Stderr, "Skipping %s: not a directory\n", path)
+			continue
+		}
+		if !scan.allowOrphans && orphaned(path) {
+			fmt.Fprintf(os.Stderr, "Skipping %s: no package.json next to it (use --allow-orphans)\n", path)
+			continue
+		}
+		paths = append(paths, path)
+	}
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+
+	var (
+		dirs      []Directory
+		mutex     sync.Mutex
+		wg        sync.WaitGroup
+		semaphore = make(chan struct{}, max(scan.workers, 1))
+	)
+	for _, path := range paths {
+		wg.Add(1)
+		go func(p string) {
+			defer wg.Done()
+			semaphore <- struct{}{}        // Acquire
+			defer func() { <-semaphore }() // Release
+
+			project, kind, ok := scan.targets.match(p, true)
+			if !ok {
+				project = filepath.Dir(p)
+			}
+			dir, err := newDirectory(ctx, p, project, kind, scan)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", p, err)
+				return
+			}
+			mutex.Lock()
+			dirs = append(dirs, dir)
+			mutex.Unlock()
+		}(path)
+	}
+	wg.Wait()
+	return dirs, nil
+}
+
+// foundTarget is a target the walk adds up the size of as it descends into it
+type foundTarget struct {
+	dir     Directory
+	tally   *sizeTally
+	pending atomic.Int64 // directories of it not read yet
+	err     error        // reading the target itself failed
+}
+
+// findNodeModules finds all directories matching scan.targets, node_modules
+// by default, reading up to scan.workers directories at a time. Targets are
+// sized by the same walk that finds them, as it descends into them.
+// Symlinked targets are never reported; other symlinked directories are
+// only traversed with scan.followSymlinks. If ctx ends first, the directories
+// sized so far are returned together with ctx's error.
+func findNodeModules(ctx context.Context, root string, scan scanOptions) ([]Directory, error) {
+	info, err := os.Lstat(root)
+	if err != nil {
+		return nil, err
+	}
+	// The walk doesn't follow a symlinked root by itself, and a link may lead
+	// into a toolchain that its own path doesn't reveal
+	if info.Mode()&os.ModeSymlink != 0 {
+		if root, err = filepath.EvalSymlinks(root); err != nil {
+			return nil, err
+		}
+		if info, err = os.Stat(root); err != nil {
+			return nil, err
+		}
+	}
+	if manager := insideToolchain(root); manager != "" {
+		fmt.Fprintf(os.Stderr, "Skipping %s: it belongs to a node installation managed by %s\n", root, manager)
+		return nil, nil
+	}
+	rootDev, haveDev := deviceID(info) // filesystem --one-file-system stays on
+
+	var (
+		nodeModules []Directory
+		mutex       sync.Mutex
+		walkWg      sync.WaitGroup
+		ignores     = newIgnoreSet(root)
+		walkers     = make(chan struct{}, max(scan.workers, 1)-1) // besides the one walking root
+		start       = time.Now()
+		walked      = []string{filepath.ToSlash(root)} // trees already covered, to avoid symlink cycles
+	)
+
+	// claimTree reports whether the tree at target is not covered yet and
+	// marks it as covered, so each symlinked tree is walked once
+	claimTree := func(target string) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, tree := range walked {
+			if isWithin(filepath.ToSlash(target), tree) {
+				return false
+			}
+		}
+		walked = append(walked, filepath.ToSlash(target))
+		return true
+	}
+
+	// finish records a target once all of it has been read
+	finish := func(t *foundTarget) {
+		if t.pending.Add(-1) > 0 {
+			return
+		}
+		if ctx.Err() != nil {
+			return // interrupted, the size would be incomplete
+		}
+		if t.err != nil {
+			slog.Info("skipping directory that could not be sized", "path", t.dir.path, "err", t.err)
+			scan.errors.add(t.dir.path, t.err)
+			return
+		}
+		t.dir.size, t.dir.unique = t.tally.totals()
+		t.dir.sized = true
+		slog.Debug("found directory", "path", t.dir.path, "size", t.dir.size)
+		mutex.Lock()
+		nodeModules = append(nodeModules, t.dir)
+		mutex.Unlock()
+	}
+
+	// tallyEntry counts an entry below a target and reports whether it is a
+	// directory to descend into
+	tallyEntry := func(t *foundTarget, path string, d fs.DirEntry) bool {
+		if t.tally.counts(d) {
+			info, err := d.Info()
+			if err != nil {
+				scan.errors.add(path, err)
+				return d.IsDir()
+			}
+			t.tally.add(info)
+		}
+		return d.IsDir()
+	}
+
+	// walkDir reads dir, which lies level levels below root, and visits its
+	// entries, or below a target into only counts them. Subdirectories go to
+	// an idle walker if there is one and are walked right here otherwise, so
+	// wide trees are read concurrently.
+	var (
+		walkDir func(dir string, level int, into *foundTarget)
+		visit   func(path string, d fs.DirEntry, level int) bool
+	)
+	walkDir = func(dir string, level int, into *foundTarget) {
+		if into != nil {
+			defer finish(into)
+		}
+		entries, err := os.ReadDir(dir)
+		switch {
+		case err != nil && into != nil && dir == into.dir.path:
+			into.err = err
+			return
+		case err != nil:
+			slog.Info("skipping unreadable path", "path", dir, "err", err)
+			scan.errors.add(dir, err) // keep going with what could be read
+		}
+		for _, entry := range entries {
+			if ctx.Err() != nil {
+				return
+			}
+			path := filepath.Join(dir, entry.Name())
+			if into != nil && !tallyEntry(into, path, entry) || into == nil && !visit(path, entry, level+1) {
+				continue
+			}
+			if into != nil {
+				into.pending.Add(1)
+			}
+			select {
+			case walkers <- struct{}{}: // Acquire
+				walkWg.Add(1)
+				go func() {
+					defer walkWg.Done()
+					defer func() { <-walkers }() // Release
+					walkDir(path, level+1, into)
+				}()
+			default:
+				walkDir(path, level+1, into)
+			}
+		}
+	}
+
+	// addTarget records a target the walk found, found level levels below
+	// root, and unless sizing is skipped walks it to add up its size
+	addTarget := func(path, project, kind string, d fs.DirEntry, level int) {
+		dir := describeDirectory(ctx, path, project, kind)
+		if ctx.Err() != nil {
+			return
+		}
+		if scan.skipSize {
+			mutex.Lock()
+			nodeModules = append(nodeModules, dir)
+			mutex.Unlock()
+			return
+		}
+		t := &foundTarget{dir: dir, tally: newSizeTally(scan.diskUsage)}
+		t.pending.Store(1)
+		if t.tally.counts(d) {
+			info, err := d.Info()
+			if err != nil {
+				t.err = err
+				finish(t)
+				return
+			}
+			t.tally.add(info)
+		}
+		if !d.IsDir() {
+			finish(t) // a target file is its own size
+			return
+		}
+		walkDir(path, level, t)
+	}
+
+	// visit decides what to do with path, found level levels below root, and
+	// reports whether to descend into it
+	visit = func(path string, d fs.DirEntry, level int) bool {
+		if ctx.Err() != nil {
+			return false
+		}
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			if _, _, ok := scan.targets.match(path, true); ok {
+				slog.Info("skipping symlinked target directory", "path", path)
+				return false
+			}
+			if !scan.followSymlinks {
+				slog.Debug("not following symlink", "path", path)
+				return false
+			}
+
+			target, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				slog.Info("skipping broken symlink", "path", path, "err", err)
+				scan.errors.add(path, err)
+				return false
+			}
+			targetInfo, err := os.Stat(target)
+			if err != nil || !targetInfo.IsDir() {
+				return false
+			}
+			if manager := insideToolchain(target); manager != "" {
+				slog.Info("skipping symlink into node installations managed by "+manager, "path", path, "target", target)
+				return false
+			}
+			if !claimTree(target) {
+				slog.Debug("skipping symlink into an already scanned tree", "path", path, "target", target)
+				return false
+			}
+			slog.Debug("following symlink", "path", path, "target", target)
+			if visit(target, fs.FileInfoToDirEntry(targetInfo), level) {
+				walkDir(target, level, nil)
+			}
+			return false
+		}
+
+		if d.IsDir() {
+			if manager := toolchainManager(path); manager != "" {
+				slog.Info("skipping node installations managed by "+manager, "path", path)
+				return false
+			}
+		}
+
+		if scan.excludes.match(path) || ignores.ignored(path, d.IsDir()) {
+			slog.Debug("skipping excluded path", "path", path)
+			return false
+		}
+
+		var (
+			project, kind string
+			isTarget      bool
+		)
+		if d.IsDir() || d.Type().IsRegular() {
+			project, kind, isTarget = scan.targets.match(path, d.IsDir())
+		}
+		if d.IsDir() && isStaged(d.Name()) {
+			project, kind, isTarget = filepath.Dir(path), "", true
+		}
+		if scan.skipHidden && d.IsDir() && path != root && strings.HasPrefix(d.Name(), ".") &&
+			!isTarget && !scan.targets.leadsTo(d.Name()) {
+			slog.Debug("skipping hidden directory", "path", path)
+			return false
+		}
+
+		if scan.oneFileSystem && haveDev && d.IsDir() {
+			// Only this check needs the lstat reading directories otherwise saves
+			if info, err := d.Info(); err == nil {
+				if dev, ok := deviceID(info); ok && dev != rootDev {
+					slog.Info("skipping directory on another filesystem", "path", path)
+					return false
+				}
+			}
+		}
+
+		if scan.maxDepth > 0 && d.IsDir() && level > scan.maxDepth {
+			slog.Debug("skipping directory beyond --max-depth", "path", path)
+			return false
+		}
+
+		// Don't descend into trees no include pattern can reach
+		if len(scan.includes) > 0 && d.IsDir() && !scan.includes.mayMatchUnder(path) {
+			slog.Debug("skipping directory outside --include", "path", path)
+			return false
+		}
+
+		if isTarget && !scan.allowOrphans && orphaned(path) {
+			slog.Info("skipping node_modules without a package.json beside it", "path", path)
+			return false
+		}
+
+		if isTarget {
+			// Targets are only walked to size them, not searched further
+			if len(scan.includes) > 0 && !scan.includes.matchSubtree(path) {
+				slog.Debug("skipping directory outside --include", "path", path)
+				return false
+			}
+			addTarget(path, project, kind, d, level)
+			return false
+		}
+
+		if !d.IsDir() {
+			return false
+		}
+		ignores.visit(path)
+		return true
+	}
+
+	if visit(root, fs.FileInfoToDirEntry(info), 0) {
+		walkDir(root, 0, nil)
+	}
+	walkWg.Wait()
+	slog.Info("scan finished", "root", root, "found", len(nodeModules), "duration", time.Since(start).Round(time.Millisecond))
+	return nodeModules, ctx.Err()
+}
+
+// normalizeRoots makes roots absolute and drops duplicates and roots nested
+// inside another root, which would only be scanned twice
+func normalizeRoots(roots []string) []string {
+	var abs []string
+	for _, root := range roots {
+		root = expandHome(root)
+		if p, err := filepath.Abs(root); err == nil {
+			root = p
+		}
+		abs = append(abs, root)
+	}
+
+	var kept []string
+	for i, root := range abs {
+		nested := false
+		for j, other := range abs {
+			if i == j {
+				continue
+			}
+			// Of two identical roots keep the first
+			if root == other && j < i || root != other && isWithin(filepath.ToSlash(root), filepath.ToSlash(other)) {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			kept = append(kept, root)
+		}
+	}
+	return kept
+}
+
+// uniqueDirectories drops repeated paths, keeping the first occurrence
+func uniqueDirectories(dirs []Directory) []Directory {
+	seen := make(map[string]bool, len(dirs))
+	var unique []Directory
+	for _, dir := range dirs {
+		if !seen[dir.path] {
+			seen[dir.path] = true
+			unique = append(unique, dir)
+		}
+	}
+	return unique
+}
+
+// sumUnique adds up the bytes deleting dirs would actually free
+func sumUnique(dirs []Directory) int64 {
+	var total int64
+	for _, dir := range dirs {
+		total += dir.unique
+	}
+	return total
+}
+
+// totalSizeString formats the combined size of dirs, or "unknown" when some
+// weren't sized
+func totalSizeString(dirs []Directory) string {
+	for _, dir := range dirs {
+		if !dir.sized {
+			return "unknown"
+		}
+	}
+	return formatSize(sumSizes(dirs))
+}
+
+// sumSizes returns the combined size of dirs
+func sumSizes(dirs []Directory) int64 {
+	var total int64
+	for _, dir := range dirs {
+		total += dir.size
+	}
+	return total
+}
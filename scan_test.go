@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// fakeHome points the home directory, and with it the toolchain roots, at a
+// fresh temporary directory
+func fakeHome(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv("NVM_DIR", "")
+	toolchainOnce = sync.Once{}
+	t.Cleanup(func() { toolchainOnce = sync.Once{} })
+	return home
+}
+
+// mkdirs creates the given directories below root
+func mkdirs(t *testing.T, root string, dirs ...string) {
+	t.Helper()
+	for _, dir := range dirs {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFindNodeModulesSkipsToolchains(t *testing.T) {
+	home := fakeHome(t)
+	npm := filepath.Join(".nvm", "versions", "node", "v20", "lib", "node_modules", "npm")
+	mkdirs(t, home, filepath.Join(npm, "node_modules", "semver"), filepath.Join("project", "node_modules"))
+	if err := os.WriteFile(filepath.Join(home, npm, "package.json"), []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(home, ".nvm", "versions"), filepath.Join(home, "project", "versions")); err != nil {
+		t.Skipf("creating a symlink: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		root   string
+		follow bool
+	}{
+		{name: "root inside a package", root: filepath.Join(home, npm)},
+		{name: "root inside the versions", root: filepath.Join(home, ".nvm", "versions")},
+		{name: "symlinked root", root: filepath.Join(home, "project", "versions")},
+		{name: "followed symlink", root: filepath.Join(home, "project"), follow: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scan, err := newScanOptions(&options{workers: 2, allowOrphans: true, followSymlinks: tt.follow, detectors: []string{"node_modules"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			dirs, err := findNodeModules(context.Background(), tt.root, scan)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, dir := range dirs {
+				if insideToolchain(dir.path) != "" {
+					t.Errorf("found %s inside the nvm installation", dir.path)
+				}
+			}
+		})
+	}
+}
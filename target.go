@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// targetName is one directory pattern split into path segments, so names
+// like .yarn/cache match the trailing components of a directory path. Each
+// segment is an exact name or a glob using *, ? and [...].
+type targetName struct {
+	raw      string
+	segments []string
+	detector *detector // nil for plain --name patterns
+	file     bool      // matches regular files instead of directories
+}
+
+// targetMatcher recognizes the directories a scan reports
+type targetMatcher []targetName
+
+// compileTargets combines plain --name patterns with the directories of
+// the given detectors
+func compileTargets(names []string, detectors []*detector) (targetMatcher, error) {
+	var m targetMatcher
+	for _, raw := range names {
+		t, err := newTargetName(raw, nil)
+		if err != nil {
+			return nil, err
+		}
+		m = append(m, t)
+	}
+	for _, d := range detectors {
+		for _, raw := range d.dirs {
+			t, err := newTargetName(raw, d)
+			if err != nil {
+				return nil, fmt.Errorf("detector %s: %w", d.name, err)
+			}
+			m = append(m, t)
+		}
+		for _, raw := range d.files {
+			t, err := newTargetName(raw, d)
+			if err != nil {
+				return nil, fmt.Errorf("detector %s: %w", d.name, err)
+			}
+			t.file = true
+			m = append(m, t)
+		}
+	}
+	return m, nil
+}
+
+// newTargetName parses one directory pattern belonging to d
+func newTargetName(raw string, d *detector) (targetName, error) {
+	name := strings.Trim(filepath.ToSlash(strings.TrimSpace(raw)), "/")
+	if name == "" {
+		return targetName{}, fmt.Errorf("empty --name pattern")
+	}
+	segments := strings.Split(name, "/")
+	for _, seg := range segments {
+		if seg == "" || seg == "." || seg == ".." {
+			return targetName{}, fmt.Errorf("invalid --name pattern %q", raw)
+		}
+		if _, err := path.Match(seg, ""); err != nil {
+			return targetName{}, fmt.Errorf("invalid --name pattern %q: %w", raw, err)
+		}
+	}
+	return targetName{raw: raw, segments: segments, detector: d}, nil
+}
+
+// match reports whether dir, a directory unless isDir is false, is a target
+// and returns the project directory it belongs to, i.e. the parent of the
+// matched name, and the name of the detector that recognized it ("" for
+// plain --name patterns)
+func (m targetMatcher) match(dir string, isDir bool) (string, string, bool) {
+	parts := strings.Split(filepath.ToSlash(filepath.Clean(dir)), "/")
+	for _, t := range m {
+		if t.file == isDir || len(t.segments) >= len(parts) {
+			continue
+		}
+		tail := parts[len(parts)-len(t.segments):]
+		matched := true
+		for i, seg := range t.segments {
+			if ok, _ := path.Match(seg, tail[i]); !ok {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		project := dir
+		for range t.segments {
+			project = filepath.Dir(project)
+		}
+		if t.detector == nil {
+			return project, "", true
+		}
+		if t.detector.accepts(project, dir) {
+			return project, t.detector.name, true
+		}
+	}
+	return "", "", false
+}
+
+// leadsTo reports whether a directory called name can hold a target, because
+// it matches the first segment of a multi-segment pattern like .yarn/cache
+func (m targetMatcher) leadsTo(name string) bool {
+	for _, t := range m {
+		if len(t.segments) > 1 {
+			if ok, _ := path.Match(t.segments[0], name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// String lists the distinct patterns for status messages
+func (m targetMatcher) String() string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, t := range m {
+		if !seen[t.raw] {
+			seen[t.raw] = true
+			names = append(names, t.raw)
+		}
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+// toolchainRoots are where Node version managers keep their installations.
+// The node_modules inside them hold npm and global packages, so deleting one
+// breaks the toolchain itself.
+var toolchainRoots = []struct {
+	manager string
+	path    string
+}{
+	{"nvm", "$NVM_DIR"},
+	{"nvm", "~/.nvm"},
+	{"nvm", "$APPDATA/nvm"},
+	{"volta", "$VOLTA_HOME"},
+	{"volta", "~/.volta"},
+	{"volta", "$LOCALAPPDATA/Volta"},
+	{"fnm", "$FNM_DIR"},
+	{"fnm", "~/.fnm"},
+	{"fnm", "~/.local/share/fnm"},
+	{"fnm", "~/.local/state/fnm_multishells"},
+	{"fnm", "~/Library/Application Support/fnm"},
+	{"fnm", "$APPDATA/fnm"},
+	{"asdf", "$ASDF_DATA_DIR"},
+	{"asdf", "~/.asdf"},
+	{"mise", "~/.local/share/mise"},
+	{"nodenv", "~/.nodenv"},
+	{"n", "$N_PREFIX/n/versions"},
+}
+
+var (
+	toolchainOnce  sync.Once
+	toolchainPaths map[string]string // expanded root to its version manager
+)
+
+// toolchainManager returns the version manager whose installations live at
+// path, or "" when path isn't a toolchain root
+func toolchainManager(path string) string {
+	toolchainOnce.Do(func() {
+		toolchainPaths = make(map[string]string)
+		for _, root := range toolchainRoots {
+			if p, ok := expandGlobal(root.path); ok {
+				toolchainPaths[p] = root.manager
+			}
+		}
+	})
+	return toolchainPaths[path]
+}
+
+// insideToolchain returns the version manager managing path or one of its
+// parents, or ""
+func insideToolchain(path string) string {
+	for dir := path; ; {
+		if manager := toolchainManager(dir); manager != "" {
+			return manager
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
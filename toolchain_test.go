@@ -0,0 +1,36 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestInsideToolchain(t *testing.T) {
+	home := fakeHome(t)
+	volta := filepath.Join(t.TempDir(), "volta")
+	t.Setenv("VOLTA_HOME", volta)
+	t.Setenv("ASDF_DATA_DIR", "")
+	t.Setenv("N_PREFIX", "")
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "nvm root", path: filepath.Join(home, ".nvm"), want: "nvm"},
+		{name: "global packages", path: filepath.Join(home, ".nvm", "versions", "node", "v20", "lib", "node_modules"), want: "nvm"},
+		{name: "from the environment", path: filepath.Join(volta, "tools", "image", "node", "20", "lib", "node_modules"), want: "volta"},
+		{name: "asdf", path: filepath.Join(home, ".asdf", "installs", "nodejs", "20", "lib", "node_modules"), want: "asdf"},
+		{name: "some other dot directory", path: filepath.Join(home, ".nvmrc-projects", "app", "node_modules")},
+		{name: "project", path: filepath.Join(home, "work", "app", "node_modules")},
+		{name: "home itself", path: home},
+		{name: "unset variable", path: filepath.Join(string(filepath.Separator), "n", "versions", "node", "20")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := insideToolchain(tt.path); got != tt.want {
+				t.Errorf("insideToolchain(%s) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
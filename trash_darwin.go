@@ -0,0 +1,37 @@
+//go:build darwin
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// moveToTrash moves path into ~/.Trash and returns where it went. Paths on
+// other volumes can't be renamed there, so they fail instead of being copied.
+func moveToTrash(path string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	trash := filepath.Join(home, ".Trash")
+
+	base := filepath.Base(path)
+	dest := filepath.Join(trash, base)
+	if _, err := os.Lstat(dest); err == nil {
+		// Finder appends the time to names already in the trash
+		dest = filepath.Join(trash, fmt.Sprintf("%s %s", base, time.Now().Format("15.04.05.000")))
+	}
+	err = os.Rename(path, dest)
+	if errors.Is(err, syscall.EXDEV) {
+		return "", fmt.Errorf("%s is on another volume than the trash, delete it without --trash", path)
+	}
+	if err != nil {
+		return "", err
+	}
+	return dest, nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package main
+
+import "errors"
+
+// moveToTrash has no trash to move to on this platform
+func moveToTrash(path string) (string, error) {
+	return "", errors.ErrUnsupported
+}
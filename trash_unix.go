@@ -0,0 +1,108 @@
+//go:build unix && !darwin
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// homeTrash returns the user's trash directory from the freedesktop.org
+// trash specification
+func homeTrash() (string, error) {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "Trash"), nil
+}
+
+// volumeTrash returns the per-user trash at the top of the filesystem
+// holding path, used when path can't be renamed into the home trash
+func volumeTrash(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	dev, _ := deviceID(info)
+	top := path
+	for {
+		parent := filepath.Dir(top)
+		pinfo, err := os.Stat(parent)
+		if parent == top || err != nil {
+			break
+		}
+		if pdev, _ := deviceID(pinfo); pdev != dev {
+			break
+		}
+		top = parent
+	}
+	return filepath.Join(top, ".Trash-"+strconv.Itoa(os.Getuid())), nil
+}
+
+// moveToTrash moves path into the trash, writing the .trashinfo file that
+// lets file managers restore it, and returns where it went
+func moveToTrash(path string) (string, error) {
+	trash, err := homeTrash()
+	if err != nil {
+		return "", err
+	}
+	dest, err := trashInto(trash, path)
+	if errors.Is(err, syscall.EXDEV) {
+		if trash, err = volumeTrash(path); err == nil {
+			dest, err = trashInto(trash, path)
+		}
+	}
+	return dest, err
+}
+
+// trashInto moves path into the trash directory trash
+func trashInto(trash, path string) (string, error) {
+	files, info := filepath.Join(trash, "files"), filepath.Join(trash, "info")
+	if err := os.MkdirAll(files, 0o700); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(info, 0o700); err != nil {
+		return "", err
+	}
+
+	// Reserve a unique name by creating its .trashinfo first
+	base := filepath.Base(path)
+	for i := 1; ; i++ {
+		name := base
+		if i > 1 {
+			name = fmt.Sprintf("%s.%d", base, i)
+		}
+		infoPath := filepath.Join(info, name+".trashinfo")
+		f, err := os.OpenFile(infoPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(f, "[Trash Info]\nPath=%s\nDeletionDate=%s\n",
+			(&url.URL{Path: path}).EscapedPath(), time.Now().Format("2006-01-02T15:04:05"))
+		if err := f.Close(); err != nil {
+			os.Remove(infoPath)
+			return "", err
+		}
+
+		dest := filepath.Join(files, name)
+		if err := os.Rename(path, dest); err != nil {
+			os.Remove(infoPath)
+			return "", err
+		}
+		return dest, nil
+	}
+}
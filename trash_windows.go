@@ -0,0 +1,57 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// SHFileOperationW constants
+const (
+	foDelete          = 0x3
+	fofSilent         = 0x4
+	fofNoConfirmation = 0x10
+	fofAllowUndo      = 0x40
+	fofNoErrorUI      = 0x400
+)
+
+// shFileOpStruct mirrors SHFILEOPSTRUCTW
+type shFileOpStruct struct {
+	hwnd                  uintptr
+	wFunc                 uint32
+	pFrom                 *uint16
+	pTo                   *uint16
+	fFlags                uint16
+	fAnyOperationsAborted int32
+	hNameMappings         uintptr
+	lpszProgressTitle     *uint16
+}
+
+var shFileOperation = syscall.NewLazyDLL("shell32.dll").NewProc("SHFileOperationW")
+
+// moveToTrash sends path to the Recycle Bin. The Recycle Bin doesn't say
+// where it keeps the files, so the returned location is empty.
+func moveToTrash(path string) (string, error) {
+	// pFrom is a list of paths ending in an extra NUL
+	from, err := syscall.UTF16FromString(path)
+	if err != nil {
+		return "", err
+	}
+	from = append(from, 0)
+
+	op := shFileOpStruct{
+		wFunc:  foDelete,
+		pFrom:  &from[0],
+		fFlags: fofAllowUndo | fofNoConfirmation | fofSilent | fofNoErrorUI,
+	}
+	ret, _, _ := shFileOperation.Call(uintptr(unsafe.Pointer(&op)))
+	if ret != 0 {
+		return "", fmt.Errorf("moving %s to the Recycle Bin failed with code %#x", path, ret)
+	}
+	if op.fAnyOperationsAborted != 0 {
+		return "", fmt.Errorf("moving %s to the Recycle Bin was aborted", path)
+	}
+	return "", nil
+}
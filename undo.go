@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// lastRun returns the most recent run in the audit log with deletions undo
+// hasn't restored yet, and those deletions
+func lastRun(entries []auditEntry) (string, []auditEntry) {
+	restored := make(map[string]bool) // by run and path
+	for _, e := range entries {
+		if e.Outcome == "restored" {
+			restored[e.Run+"\x00"+e.Path] = true
+		}
+	}
+
+	var (
+		run  string
+		list []auditEntry
+	)
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.Run == "" || run != "" && e.Run != run {
+			continue
+		}
+		if (e.Outcome == "deleted" || e.Outcome == "trashed") && !restored[e.Run+"\x00"+e.Path] {
+			run = e.Run
+			list = append([]auditEntry{e}, list...)
+		}
+	}
+	return run, list
+}
+
+// restoreFromTrash moves a trashed directory back to where it came from and
+// drops its freedesktop.org .trashinfo file, if it has one
+func restoreFromTrash(trashed, path string) error {
+	if err := os.Rename(trashed, path); err != nil {
+		return err
+	}
+	info := filepath.Join(filepath.Dir(filepath.Dir(trashed)), "info", filepath.Base(trashed)+".trashinfo")
+	if err := os.Remove(info); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		printWarning("could not remove %s: %v", info, err)
+	}
+	return nil
+}
+
+// undoEntry brings back one directory of a run
+func undoEntry(ctx context.Context, e auditEntry) error {
+	if _, err := os.Lstat(e.Path); err == nil {
+		return fmt.Errorf("%s exists again, leaving it alone", e.Path)
+	}
+	switch {
+	case e.Outcome == "trashed" && e.Trash != "":
+		return restoreFromTrash(e.Trash, e.Path)
+	case e.Outcome == "trashed":
+		return fmt.Errorf("%s is in the Recycle Bin, restore it from there", e.Path)
+	case e.Archive != "":
+		if err := verifyArchive(e.Archive, e.SHA256); err != nil {
+			return err
+		}
+		if err := extractArchive(ctx, e.Archive, e.Path); err != nil {
+			os.RemoveAll(e.Path)
+			return err
+		}
+		return nil
+	}
+	return fmt.Errorf("%s was deleted permanently, reinstall it instead", e.Path)
+}
+
+// runUndo restores what the most recent deletion run removed, from the trash
+// or from archives, using the audit log
+func runUndo(ctx context.Context, opts *options, _ []string) error {
+	unlock, err := lockRun(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	path := opts.logFile
+	if path == "" {
+		var err error
+		if path, err = auditLogPath(); err != nil {
+			return err
+		}
+	}
+	entries, err := readAuditLog(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintln(opts.progress(), "Nothing to undo, the audit log is empty.")
+		return errNothingFound
+	}
+	if err != nil {
+		return fmt.Errorf("reading audit log: %w", err)
+	}
+
+	run, list := lastRun(entries)
+	if len(list) == 0 {
+		fmt.Fprintln(opts.progress(), "Nothing to undo.")
+		return errNothingFound
+	}
+	fmt.Fprintf(opts.progress(), "Undoing the run of %s (%d directories)\n", run, len(list))
+	if opts.dryRun {
+		for _, e := range list {
+			how := "can't be restored, it was deleted permanently"
+			switch {
+			case e.Outcome == "trashed":
+				how = "from the trash"
+			case e.Archive != "":
+				how = "from " + e.Archive
+			}
+			fmt.Printf("  %s (%s)\n", e.Path, how)
+		}
+		fmt.Println("Nothing was restored (--dry-run).")
+		return nil
+	}
+
+	audit, err := openAuditLog(path)
+	if err != nil {
+		printWarning("audit log disabled: %v", err)
+	}
+	defer audit.Close()
+
+	var restored, failed int
+	for _, e := range list {
+		if err := undoEntry(ctx, e); err != nil {
+			printError("ERROR:", err)
+			failed++
+			continue
+		}
+		if err := audit.recordRestore(e.Path, run); err != nil {
+			printWarning("writing audit log failed: %v", err)
+		}
+		fmt.Fprintf(opts.progress(), "Restored [%s] ✅\n", e.Path)
+		restored++
+	}
+	fmt.Printf("\nRestored %d directories, %d could not be restored\n", restored, failed)
+	if failed > 0 {
+		return &codeError{
+			code: exitPartialFailure,
+			err:  fmt.Errorf("%d of %d directories could not be restored", failed, len(list)),
+		}
+	}
+	return nil
+}
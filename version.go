@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build metadata, injected at build time with
+//
+//	go build -ldflags "-X main.version=1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%FT%TZ)"
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+// buildInfo returns the commit and build date, falling back to the VCS
+// details the Go toolchain embeds when ldflags didn't set them
+func buildInfo() (string, string) {
+	rev, built := commit, date
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && rev == "":
+				rev = s.Value
+			case s.Key == "vcs.time" && built == "":
+				built = s.Value
+			}
+		}
+	}
+	if rev == "" {
+		rev = "unknown"
+	}
+	if built == "" {
+		built = "unknown"
+	}
+	return rev, built
+}
+
+// runVersion prints the version and build metadata
+func runVersion(ctx context.Context, opts *options, args []string) error {
+	rev, built := buildInfo()
+	fmt.Printf("clean-modules %s\n", version)
+	fmt.Printf("  commit:     %s\n", rev)
+	fmt.Printf("  built:      %s\n", built)
+	fmt.Printf("  go version: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// walkError is a path that couldn't be read while scanning or sizing
+type walkError struct {
+	path string
+	err  error
+}
+
+// walkErrors collects the errors found by concurrent scanners so results
+// that under-report can say so. A nil collector discards everything.
+type walkErrors struct {
+	mu   sync.Mutex
+	list []walkError
+}
+
+// add records that path couldn't be read
+func (w *walkErrors) add(path string, err error) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.list = append(w.list, walkError{path: path, err: err})
+}
+
+// all returns the recorded errors ordered by path
+func (w *walkErrors) all() []walkError {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	list := append([]walkError(nil), w.list...)
+	sort.Slice(list, func(i, j int) bool { return list[i].path < list[j].path })
+	return list
+}
+
+// report prints a one-line count of the errors, or every error when
+// showAll is set
+func (w *walkErrors) report(out io.Writer, showAll bool) {
+	list := w.all()
+	if len(list) == 0 {
+		return
+	}
+	if !showAll {
+		printWarning("%d paths could not be read, results may under-report (use --show-errors to list them)", len(list))
+		return
+	}
+	printWarning("%d paths could not be read:", len(list))
+	for _, e := range list {
+		fmt.Fprintf(out, "  %s: %v\n", e.path, e.err)
+	}
+}
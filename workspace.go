@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// workspace is a monorepo root and the globs naming its member packages
+type workspace struct {
+	root    string
+	members globList
+}
+
+var (
+	workspaceMu    sync.Mutex
+	workspaceCache = make(map[string]*workspace) // by directory, nil when it isn't a root
+)
+
+// workspacePatterns returns the member globs of a workspace root declared
+// in pnpm-workspace.yaml, the workspaces field of package.json or
+// lerna.json, and whether dir is a root at all
+func workspacePatterns(dir string) ([]string, bool) {
+	if data, err := os.ReadFile(filepath.Join(dir, "pnpm-workspace.yaml")); err == nil {
+		var cfg struct {
+			Packages []string `yaml:"packages"`
+		}
+		yaml.Unmarshal(data, &cfg)
+		return cfg.Packages, true
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "package.json")); err == nil {
+		var m struct {
+			Workspaces json.RawMessage `json:"workspaces"`
+		}
+		if json.Unmarshal(data, &m) == nil && len(m.Workspaces) > 0 {
+			// Either a list of globs or {"packages": [...]}, as Yarn classic allows
+			var list []string
+			if json.Unmarshal(m.Workspaces, &list) != nil {
+				var obj struct {
+					Packages []string `json:"packages"`
+				}
+				json.Unmarshal(m.Workspaces, &obj)
+				list = obj.Packages
+			}
+			return list, true
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "lerna.json")); err == nil {
+		cfg := struct {
+			Packages []string `json:"packages"`
+		}{Packages: []string{"packages/*"}}
+		json.Unmarshal(data, &cfg)
+		return cfg.Packages, true
+	}
+	return nil, false
+}
+
+// loadWorkspace returns the workspace rooted at dir, or nil
+func loadWorkspace(dir string) *workspace {
+	workspaceMu.Lock()
+	defer workspaceMu.Unlock()
+	if ws, ok := workspaceCache[dir]; ok {
+		return ws
+	}
+
+	var ws *workspace
+	if patterns, ok := workspacePatterns(dir); ok {
+		ws = &workspace{root: dir}
+		for _, p := range patterns {
+			if strings.HasPrefix(p, "!") {
+				continue // exclusions only narrow the members down
+			}
+			if g, err := compileGlob(filepath.Join(dir, p)); err == nil {
+				ws.members = append(ws.members, g)
+			}
+		}
+	}
+	workspaceCache[dir] = ws
+	return ws
+}
+
+// workspaceRoot returns the root of the monorepo workspace project belongs
+// to, which may be project itself, or "" outside any workspace
+func workspaceRoot(project string) string {
+	for dir := project; ; {
+		if ws := loadWorkspace(dir); ws != nil && (dir == project || ws.members.match(project)) {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// groupWorkspaces splits dirs into groups that share a workspace, in the
+// order their first member appears. Directories outside a workspace, or
+// alone in theirs, form groups of one.
+func groupWorkspaces(dirs []Directory) [][]Directory {
+	var groups [][]Directory
+	index := make(map[string]int)
+	count := make(map[string]int)
+	for _, dir := range dirs {
+		if dir.workspace != "" {
+			count[dir.workspace]++
+		}
+	}
+	for _, dir := range dirs {
+		if dir.workspace == "" || count[dir.workspace] < 2 {
+			groups = append(groups, []Directory{dir})
+			continue
+		}
+		i, ok := index[dir.workspace]
+		if !ok {
+			i = len(groups)
+			index[dir.workspace] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], dir)
+	}
+	return groups
+}